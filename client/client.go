@@ -10,10 +10,480 @@ package client
 **/
 
 import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/beito123/mimi"
+	"github.com/beito123/mimi/pks"
+	"github.com/gorilla/websocket"
+	uuid "github.com/satori/go.uuid"
+
 	"github.com/sirupsen/logrus"
 )
 
 var logger = logrus.New()
 
+// SetLogLevel sets a log level for the client logger
+func SetLogLevel(level logrus.Level) {
+	logger.SetLevel(level)
+}
+
+const (
+	RequestTimeout = 10 * time.Second
+	StopTimeout    = 2 * time.Minute // stopping a program may take a while
+
+	MaxMessagesStack = 64
+	MaxStatusStack   = 16
+	MaxErrorStack    = 16
+)
+
+var (
+	ErrClosed  = errors.New("the connection is closed")
+	ErrTimeout = errors.New("timed out waiting for a response")
+)
+
+// Dial connects to a mimi server with the token and returns a client after handshaking
+// If rawurl has no path, mimi.StreamPath is used
+func Dial(rawurl string, token string) (*Client, error) {
+	return dial(rawurl, token, nil)
+}
+
+// DialUser connects to a mimi server as a user and returns a client after handshaking
+func DialUser(rawurl string, name string, password string) (*Client, error) {
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(name+":"+password)))
+
+	return dial(rawurl, "", header)
+}
+
+func dial(rawurl string, token string, header http.Header) (*Client, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(u.Path) == 0 || u.Path == "/" {
+		u.Path = mimi.StreamPath
+	}
+
+	if len(token) > 0 {
+		query := u.Query()
+		query.Set(mimi.QueryToken, token)
+		u.RawQuery = query.Encode()
+	}
+
+	dialer := &websocket.Dialer{
+		HandshakeTimeout: mimi.HandshakeTimeout,
+	}
+
+	conn, _, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		return nil, err
+	}
+
+	cid, err := uuid.NewV4()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client := &Client{
+		Session: &ClientSession{
+			BaseSession: mimi.NewBaseSession(conn, uuid.Nil, cid),
+		},
+		handshakeCh: make(chan error, 1),
+		messages:    make(chan *pks.ConsoleMessages, MaxMessagesStack),
+		statuses:    make(chan *pks.ProgramStatus, MaxStatusStack),
+		errors:      make(chan *mimi.ErrorMessage, MaxErrorStack),
+		closeCh:     make(chan bool),
+	}
+
+	client.handlers = []mimi.PacketHandler{
+		&ClientSessionHandler{
+			Client: client,
+		},
+	}
+
+	client.Session.Start()
+
+	go client.update()
+
+	select {
+	case err = <-client.handshakeCh:
+	case <-time.After(mimi.HandshakeTimeout):
+		err = ErrTimeout
+	case <-client.closeCh:
+		err = ErrClosed
+	}
+
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+type pending struct {
+	req    byte // ID of the request packet
+	ids    []byte
+	accept func(pks.Packet) bool // optional, filters packets having ids
+	ch     chan pks.Packet
+}
+
+func (p *pending) match(pk pks.Packet) bool {
+	// errors of other requests are sent to Errors
+	epk, ok := pk.(*pks.ErrorMessage)
+	if ok {
+		return epk.Request == p.req
+	}
+
+	for _, v := range p.ids {
+		if v == pk.ID() {
+			return p.accept == nil || p.accept(pk)
+		}
+	}
+
+	return false
+}
+
+// Client is a client for a mimi server
 type Client struct {
+	Session *ClientSession
+
+	handlers    []mimi.PacketHandler
+	handshakeCh chan error
+
+	requestMutex sync.Mutex
+	pendingMutex sync.Mutex
+	pending      *pending
+
+	messages chan *pks.ConsoleMessages
+	statuses chan *pks.ProgramStatus
+	errors   chan *mimi.ErrorMessage
+
+	closeCh   chan bool
+	closeOnce sync.Once
+}
+
+func (client *Client) update() {
+	ticker := time.NewTicker(mimi.UpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.closeCh:
+			return
+		case <-ticker.C:
+		}
+
+		if client.Session.State() == mimi.StateDisconnected {
+			client.Close()
+			return
+		}
+
+		client.Session.Update(client.handlers)
+	}
+}
+
+func (client *Client) handshake(err error) {
+	select {
+	case client.handshakeCh <- err:
+	default:
+	}
+}
+
+// receive delivers a packet to a pending request or the channels
+func (client *Client) receive(pk pks.Packet) {
+	client.pendingMutex.Lock()
+	p := client.pending
+	if p != nil && p.match(pk) {
+		client.pending = nil
+	} else {
+		p = nil
+	}
+	client.pendingMutex.Unlock()
+
+	if p != nil {
+		p.ch <- pk
+		return
+	}
+
+	switch npk := pk.(type) {
+	case *pks.ConsoleMessages:
+		select {
+		case client.messages <- npk:
+		default:
+			logger.Warnf("Dropped console messages, the channel is full")
+		}
+	case *pks.ProgramStatus:
+		select {
+		case client.statuses <- npk:
+		default:
+			logger.Debugf("Dropped a program status, the channel is full")
+		}
+	case *pks.ErrorMessage:
+		select {
+		case client.errors <- mimi.GetError(npk.Error):
+		default:
+			logger.Debugf("Dropped an error message, the channel is full")
+		}
+	default:
+		logger.Debugf("Received an unexpected packet ID:%d", pk.ID())
+	}
+}
+
+// request sends pk and waits for a packet having one of ids or an ErrorMessage for pk
+func (client *Client) request(pk pks.Packet, ids ...byte) (pks.Packet, error) {
+	return client.requestFilter(pk, RequestTimeout, nil, ids...)
+}
+
+// requestFilter sends pk and waits for a packet having one of ids and accepted by accept
+func (client *Client) requestFilter(pk pks.Packet, timeout time.Duration, accept func(pks.Packet) bool, ids ...byte) (pks.Packet, error) {
+	client.requestMutex.Lock()
+	defer client.requestMutex.Unlock()
+
+	p := &pending{
+		req:    pk.ID(),
+		ids:    ids,
+		accept: accept,
+		ch:     make(chan pks.Packet, 1),
+	}
+
+	client.pendingMutex.Lock()
+	client.pending = p
+	client.pendingMutex.Unlock()
+
+	defer func() {
+		client.pendingMutex.Lock()
+		if client.pending == p {
+			client.pending = nil
+		}
+		client.pendingMutex.Unlock()
+	}()
+
+	err := client.Session.SendPacket(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-p.ch:
+		epk, ok := res.(*pks.ErrorMessage)
+		if ok {
+			return nil, mimi.GetError(epk.Error)
+		}
+
+		return res, nil
+	case <-time.After(timeout):
+		return nil, ErrTimeout
+	case <-client.closeCh:
+		return nil, ErrClosed
+	}
+}
+
+// send sends pk without waiting for a response
+func (client *Client) send(pk pks.Packet) error {
+	if client.Closed() {
+		return ErrClosed
+	}
+
+	return client.Session.SendPacket(pk)
+}
+
+// ListPrograms returns programs registered on the server
+func (client *Client) ListPrograms() ([]*pks.Program, error) {
+	res, err := client.request(&pks.RequestProgramList{}, pks.IDResponseProgramList)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*pks.ResponseProgramList).Programs, nil
+}
+
+// StartProgram starts a program and returns its status
+func (client *Client) StartProgram(name string) (*pks.ProgramStatus, error) {
+	// statuses of other programs are sent to ProgramStatuses
+	accept := func(pk pks.Packet) bool {
+		return pk.(*pks.ProgramStatus).ProgramName == name
+	}
+
+	res, err := client.requestFilter(&pks.StartProgram{
+		ProgramName: name,
+	}, RequestTimeout, accept, pks.IDProgramStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*pks.ProgramStatus), nil
+}
+
+// StopProgram stops a program and returns its status
+// If restart is true, the program is started again
+func (client *Client) StopProgram(name string, restart bool) (*pks.ProgramStatus, error) {
+	// statuses in the middle of stopping are sent to ProgramStatuses
+	accept := func(pk pks.Packet) bool {
+		status := pk.(*pks.ProgramStatus)
+		if status.ProgramName != name {
+			return false
+		}
+
+		if restart {
+			return status.State != pks.ConsoleStopping && status.State != pks.ConsoleStopped
+		}
+
+		return !status.Running
+	}
+
+	res, err := client.requestFilter(&pks.StopProgram{
+		ProgramName: name,
+		Restart:     restart,
+	}, StopTimeout, accept, pks.IDProgramStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*pks.ProgramStatus), nil
+}
+
+// ExitStatus returns the last exit of a program
+func (client *Client) ExitStatus(name string) (*pks.ExitStatus, error) {
+	res, err := client.request(&pks.RequestExitStatus{
+		ProgramName: name,
+	}, pks.IDExitStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*pks.ExitStatus), nil
+}
+
+// ListConsoles returns consoles running on the server
+func (client *Client) ListConsoles() ([]*pks.Console, error) {
+	res, err := client.request(&pks.RequestConsoleList{}, pks.IDResponseConsoleList)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*pks.ResponseConsoleList).Consoles, nil
+}
+
+// JoinConsole joins a console
+// Messages of the console are sent to ConsoleMessages in format (pks.FormatRaw etc.),
+// starting from the last n lines printed before joining
+// The server doesn't reply on success, errors are sent to Errors
+func (client *Client) JoinConsole(uid uuid.UUID, format byte, n uint16) error {
+	return client.send(&pks.JoinConsole{
+		ConsoleUUID: uid,
+		Format:      format,
+		Lines:       n,
+	})
+}
+
+// ConsoleHistory returns n lines at most of a console before seq
+// If seq is 0, it returns the last lines. If n is 0, the server decides the number
+func (client *Client) ConsoleHistory(uid uuid.UUID, seq int64, n uint16, format byte) (*pks.ConsoleHistory, error) {
+	accept := func(pk pks.Packet) bool {
+		return pk.(*pks.ConsoleHistory).ConsoleUUID == uid
+	}
+
+	res, err := client.requestFilter(&pks.RequestConsoleHistory{
+		ConsoleUUID: uid,
+		Seq:         seq,
+		Count:       n,
+		Format:      format,
+	}, RequestTimeout, accept, pks.IDConsoleHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*pks.ConsoleHistory), nil
+}
+
+// Search returns the last lines of a console matching a request
+// The result has lines of MaxResults at most, ConsoleUUID of req is required
+func (client *Client) Search(req *pks.RequestSearch) (*pks.SearchResult, error) {
+	accept := func(pk pks.Packet) bool {
+		return pk.(*pks.SearchResult).ConsoleUUID == req.ConsoleUUID
+	}
+
+	res, err := client.requestFilter(req, RequestTimeout, accept, pks.IDSearchResult)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*pks.SearchResult), nil
+}
+
+// QuitConsole quits a joined console
+func (client *Client) QuitConsole(uid uuid.UUID) error {
+	return client.send(&pks.QuitConsole{
+		ConsoleUUID: uid,
+	})
+}
+
+// SendCommands sends commands to a joined console
+func (client *Client) SendCommands(commands ...string) error {
+	return client.send(&pks.SendCommands{
+		Commands: commands,
+	})
+}
+
+// ResizeConsole changes the window size of a joined console
+// It only affects consoles running on a pseudo-terminal
+func (client *Client) ResizeConsole(uid uuid.UUID, rows uint16, cols uint16) error {
+	return client.send(&pks.ResizeConsole{
+		ConsoleUUID: uid,
+		Rows:        rows,
+		Cols:        cols,
+	})
+}
+
+// ConsoleMessages returns a channel receiving messages of a joined console
+func (client *Client) ConsoleMessages() <-chan *pks.ConsoleMessages {
+	return client.messages
+}
+
+// ProgramStatuses returns a channel receiving program statuses not replied to requests
+func (client *Client) ProgramStatuses() <-chan *pks.ProgramStatus {
+	return client.statuses
+}
+
+// Errors returns a channel receiving errors not replied to requests
+func (client *Client) Errors() <-chan *mimi.ErrorMessage {
+	return client.errors
+}
+
+// Done returns a channel closed when the connection is closed
+func (client *Client) Done() <-chan bool {
+	return client.closeCh
+}
+
+func (client *Client) Closed() bool {
+	select {
+	case <-client.closeCh:
+		return true
+	default:
+	}
+
+	return false
+}
+
+// Close closes the connection
+// It waits for queued packets to be sent up to mimi.HandshakeTimeout
+func (client *Client) Close() {
+	client.closeOnce.Do(func() {
+		client.Session.Close()
+
+		close(client.closeCh)
+
+		select {
+		case <-client.Session.Done():
+		case <-time.After(mimi.HandshakeTimeout):
+		}
+	})
 }
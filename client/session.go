@@ -10,12 +10,22 @@ package client
 **/
 
 import (
+	"errors"
+
 	"github.com/beito123/mimi"
 	"github.com/beito123/mimi/pks"
 )
 
+type ClientSession struct {
+	mimi.BaseSession
+}
+
+func (session *ClientSession) Update(handlers []mimi.PacketHandler) {
+	session.Process(session, handlers)
+}
+
 type ClientSessionHandler struct {
-	//
+	Client *Client
 }
 
 func (sp *ClientSessionHandler) HandlePacket(session mimi.Session, pk pks.Packet) {
@@ -47,13 +57,21 @@ func (sp *ClientSessionHandler) HandlePacket(session mimi.Session, pk pks.Packet
 
 		session.SetState(mimi.StateConnected)
 
+		sp.Client.handshake(nil)
+
 		logger.Debugf("Established a connection for a server")
+	case *pks.IncompatibleProtocol:
+		logger.Debugf("Received Incompatible Protocol packet")
+
+		sp.Client.handshake(errors.New("incompatible protocol with the server"))
 	case *pks.DisconnectionNotification:
 		logger.Debugf("Received disconnection packet IP: %s CID: %s", session.Addr().String(), session.ClientUUID().String())
 
 		if session.State() != mimi.StateDisconnected {
 			session.Close()
 		}
+	case *pks.ResponseProgramList, *pks.ResponseConsoleList, *pks.ProgramStatus, *pks.ExitStatus, *pks.ConsoleHistory, *pks.SearchResult, *pks.ErrorMessage, *pks.ConsoleMessages:
+		sp.Client.receive(pk)
 	default:
 		logger.Debugf("Received unknown packet ID:%d", npk.ID())
 	}
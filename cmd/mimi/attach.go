@@ -0,0 +1,250 @@
+package main
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/beito123/mimi"
+	"github.com/beito123/mimi/client"
+	"github.com/beito123/mimi/color"
+	"github.com/beito123/mimi/pks"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	EnvToken      = "MIMI_TOKEN"
+	EnvUser       = "MIMI_USER"
+	EnvPassword   = "MIMI_PASSWORD"
+	DefaultDetach = "~."
+	DefaultLines  = 100
+	TimeFormat    = "15:04:05"
+)
+
+func attach(args []string) error {
+	flags := flag.NewFlagSet("attach", flag.ExitOnError)
+	token := flags.String("token", os.Getenv(EnvToken), "token for the server (default $"+EnvToken+")")
+	user := flags.String("user", os.Getenv(EnvUser), "user name, the password is read from $"+EnvPassword+" (default $"+EnvUser+")")
+	detach := flags.String("detach", DefaultDetach, "line detaching from the console")
+	start := flags.Bool("start", false, "start the program if it isn't running")
+	format := flags.String("format", "auto", "output format: ansi, plain, raw or auto")
+	history := flags.Uint("lines", DefaultLines, "number of last lines shown on attaching")
+	timestamps := flags.Bool("time", false, "show the time each line was printed")
+	flags.Parse(args)
+
+	if *format == "auto" {
+		*format = "plain"
+		if isTerminal(os.Stdout) {
+			*format = "ansi"
+		}
+	}
+
+	pkFormat, ok := formats[*format]
+	if !ok {
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+
+	if flags.NArg() < 2 {
+		return errors.New("usage: mimi attach [--token token | --user name] <server-url> <program|console-uuid>")
+	}
+
+	var cl *client.Client
+	var err error
+	if len(*user) > 0 {
+		cl, err = client.DialUser(flags.Arg(0), *user, os.Getenv(EnvPassword))
+	} else {
+		cl, err = client.Dial(flags.Arg(0), *token)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer cl.Close()
+
+	uid, err := findConsole(cl, flags.Arg(1), *start)
+	if err != nil {
+		return err
+	}
+
+	err = cl.JoinConsole(uid, pkFormat, uint16(*history))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "[mimi] Attached to %s, type %q to detach\n", uid.String(), *detach)
+
+	stopWinsize := watchWinsize(cl, uid)
+	defer stopWinsize()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok || line == *detach {
+				cl.QuitConsole(uid)
+
+				fmt.Fprintln(os.Stderr, "[mimi] Detached")
+
+				return nil
+			}
+
+			err = cl.SendCommands(line)
+			if err != nil {
+				return err
+			}
+		case pk := <-cl.ConsoleMessages():
+			for _, msg := range pk.Messages {
+				text := msg.Text
+				if pkFormat == pks.FormatSpans {
+					text = ansi(msg.Spans)
+				}
+
+				if *timestamps && msg.Time > 0 {
+					t := time.Unix(0, msg.Time*int64(time.Millisecond))
+					text = t.Format(TimeFormat) + " " + text
+				}
+
+				if msg.Stream == pks.StreamStderr || msg.Stream == pks.StreamDropped {
+					fmt.Fprintln(os.Stderr, text)
+				} else {
+					fmt.Println(text)
+				}
+			}
+		case status := <-cl.ProgramStatuses():
+			if status.ConsoleUUID != uid {
+				break
+			}
+
+			restart := ""
+			if !status.Running && status.Restarting {
+				restart = ", restarting"
+			}
+
+			fmt.Fprintf(os.Stderr, "[mimi] %s is %s%s%s\n", status.ProgramName, pks.ConsoleStateName(status.State), exitString(status.Exit), restart)
+
+			// a crashed program may be started again by the restart policy
+			if !status.Running && !status.Restarting {
+				return nil
+			}
+		case e := <-cl.Errors():
+			fmt.Fprintf(os.Stderr, "[mimi] Error %d: %s\n", e.ID, e.Message)
+
+			if e.ID == mimi.ErrIDConsoleNotFound || e.ID == mimi.ErrIDConsoleAlreadyClosed {
+				return e
+			}
+		case <-sig:
+			cl.QuitConsole(uid)
+
+			fmt.Fprintln(os.Stderr, "[mimi] Detached")
+
+			return nil
+		case <-cl.Done():
+			return errors.New("the connection was closed by the server")
+		}
+	}
+}
+
+var formats = map[string]byte{
+	"ansi":  pks.FormatSpans,
+	"plain": pks.FormatPlain,
+	"raw":   pks.FormatRaw,
+}
+
+// ansi returns a text colored spans for terminals
+func ansi(spans []*pks.Span) string {
+	cs := make([]*color.Span, len(spans))
+	for i, span := range spans {
+		cs[i] = &color.Span{
+			Text:  span.Text,
+			Color: span.Color,
+			Style: color.Style(span.Style),
+		}
+	}
+
+	return color.ANSI(cs)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// findConsole returns the uuid of a console by target
+// target is a console's uuid or a program's name
+func findConsole(cl *client.Client, target string, start bool) (uuid.UUID, error) {
+	uid, err := uuid.FromString(target)
+	if err == nil {
+		return uid, nil
+	}
+
+	consoles, err := cl.ListConsoles()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	name := strings.ToLower(target)
+	for _, con := range consoles {
+		if con.Program != nil && con.Program.Name == name {
+			return con.UUID, nil
+		}
+	}
+
+	if !start {
+		return uuid.Nil, fmt.Errorf("%s isn't running, use --start to start it", target)
+	}
+
+	status, err := cl.StartProgram(name)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return status.ConsoleUUID, nil
+}
+
+// exitString returns a description of exit
+func exitString(exit *pks.Exit) string {
+	if exit == nil {
+		return ""
+	}
+
+	duration := time.Duration(exit.Duration) * time.Millisecond
+
+	if len(exit.Signal) > 0 {
+		return fmt.Sprintf(" (killed by %s after %s)", exit.Signal, duration.String())
+	}
+
+	return fmt.Sprintf(" (exit code %d after %s)", exit.Code, duration.String())
+}
@@ -9,8 +9,63 @@ package main
  * http://opensource.org/licenses/mit-license.php
 **/
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands = []*command{
+	{
+		Name:  "serve",
+		Usage: "serve [--config mimi.toml]",
+		Run:   serve,
+	},
+	{
+		Name:  "attach",
+		Usage: "attach [--token token | --user name] [--detach ~.] [--start] [--format auto] [--lines 100] [--time] <server-url> <program|console-uuid>",
+		Run:   attach,
+	},
+	{
+		Name:  "passwd",
+		Usage: "passwd [--cost 10]",
+		Run:   passwd,
+	},
+}
 
 func main() {
-	fmt.Println("Hello world!")
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.Name != os.Args[1] {
+			continue
+		}
+
+		err := cmd.Run(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mimi %s: %s\n", cmd.Name, err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  mimi %s\n", cmd.Usage)
+	}
 }
@@ -0,0 +1,69 @@
+package main
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+// passwd reads a password from stdin and prints its hash for users config
+func passwd(args []string) error {
+	flags := flag.NewFlagSet("passwd", flag.ExitOnError)
+	cost := flags.Int("cost", bcrypt.DefaultCost, "bcrypt cost")
+	flags.Parse(args)
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+
+	if len(password) == 0 {
+		return errors.New("empty password")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), *cost)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(hash))
+
+	return nil
+}
+
+// readPassword reads a line from stdin without echoing it on a terminal
+func readPassword() (string, error) {
+	if isTerminal(os.Stdin) {
+		fmt.Fprint(os.Stderr, "Password: ")
+
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", errors.New("couldn't read a password")
+		}
+
+		return string(b), nil
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(password) == 0 {
+		return "", errors.New("couldn't read a password")
+	}
+
+	return strings.TrimRight(password, "\r\n"), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/beito123/mimi/config"
+	"github.com/beito123/mimi/server"
+
+	"github.com/sirupsen/logrus"
+)
+
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	path := flags.String("config", "mimi.toml", "path to a config file")
+	flags.Parse(args)
+
+	conf, err := config.Load(*path)
+	if err != nil {
+		return err
+	}
+
+	if conf.Server.Debug || conf.Development.DebugMode {
+		logrus.SetLevel(logrus.DebugLevel)
+		server.SetLogLevel(logrus.DebugLevel)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ser, err := server.StartServer(ctx, *conf)
+	if err != nil {
+		return err
+	}
+
+	return ser.Wait()
+}
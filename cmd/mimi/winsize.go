@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/beito123/mimi/client"
+	"github.com/creack/pty"
+	uuid "github.com/satori/go.uuid"
+)
+
+// watchWinsize sends the terminal size to the console now and whenever it changes
+// It returns a function to stop watching
+func watchWinsize(cl *client.Client, uid uuid.UUID) func() {
+	resize := func() error {
+		rows, cols, err := pty.Getsize(os.Stdin)
+		if err != nil {
+			return err
+		}
+
+		return cl.ResizeConsole(uid, uint16(rows), uint16(cols))
+	}
+
+	err := resize()
+	if err != nil { // stdin isn't a terminal
+		return func() {}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+
+	go func() {
+		for range ch {
+			resize()
+		}
+	}()
+
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"github.com/beito123/mimi/client"
+	uuid "github.com/satori/go.uuid"
+)
+
+func watchWinsize(cl *client.Client, uid uuid.UUID) func() {
+	return func() {}
+}
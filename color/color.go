@@ -0,0 +1,346 @@
+package color
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NoColor means the default color of a client
+const NoColor int32 = -1
+
+type Style byte
+
+const (
+	StyleBold Style = 1 << iota
+	StyleItalic
+	StyleUnderline
+	StyleStrikethrough
+	StyleObfuscated
+)
+
+// Span is a text having the same color and styles
+type Span struct {
+	Text  string
+	Color int32 // 0xRRGGBB or NoColor
+	Style Style
+}
+
+// MinecraftColors is colors for §0 to §f
+var MinecraftColors = map[rune]int32{
+	'0': 0x000000,
+	'1': 0x0000AA,
+	'2': 0x00AA00,
+	'3': 0x00AAAA,
+	'4': 0xAA0000,
+	'5': 0xAA00AA,
+	'6': 0xFFAA00,
+	'7': 0xAAAAAA,
+	'8': 0x555555,
+	'9': 0x5555FF,
+	'a': 0x55FF55,
+	'b': 0x55FFFF,
+	'c': 0xFF5555,
+	'd': 0xFF55FF,
+	'e': 0xFFFF55,
+	'f': 0xFFFFFF,
+}
+
+// MinecraftStyles is styles for §k to §o
+var MinecraftStyles = map[rune]Style{
+	'k': StyleObfuscated,
+	'l': StyleBold,
+	'm': StyleStrikethrough,
+	'n': StyleUnderline,
+	'o': StyleItalic,
+}
+
+// ANSIColors is 16 colors for SGR 30-37 and 90-97
+var ANSIColors = [16]int32{
+	0x000000, 0xAA0000, 0x00AA00, 0xAA5500, 0x0000AA, 0xAA00AA, 0x00AAAA, 0xAAAAAA,
+	0x555555, 0xFF5555, 0x55FF55, 0xFFFF55, 0x5555FF, 0xFF55FF, 0x55FFFF, 0xFFFFFF,
+}
+
+const (
+	escape       = '\x1b'
+	bell         = '\x07'
+	minecraftTag = '§'
+)
+
+type parser struct {
+	spans []*Span
+	text  strings.Builder
+	color int32
+	style Style
+}
+
+// set changes the current color and style
+func (p *parser) set(color int32, style Style) {
+	if p.color == color && p.style == style {
+		return
+	}
+
+	p.flush()
+
+	p.color = color
+	p.style = style
+}
+
+func (p *parser) flush() {
+	if p.text.Len() == 0 {
+		return
+	}
+
+	p.spans = append(p.spans, &Span{
+		Text:  p.text.String(),
+		Color: p.color,
+		Style: p.style,
+	})
+
+	p.text.Reset()
+}
+
+// Parse parses ANSI escape sequences and Minecraft color codes (§) in line
+// It returns spans of the text without them
+func Parse(line string) []*Span {
+	p := &parser{
+		color: NoColor,
+	}
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		switch {
+		case r == escape:
+			i = p.escape(runes, i)
+		case r == minecraftTag && i+1 < len(runes):
+			i++
+			p.minecraft(runes[i])
+		case r < 0x20 && r != '\t': // other control characters
+		default:
+			p.text.WriteRune(r)
+		}
+	}
+
+	p.flush()
+
+	return p.spans
+}
+
+// Strip returns line without ANSI escape sequences and Minecraft color codes
+func Strip(line string) string {
+	var b strings.Builder
+	for _, span := range Parse(line) {
+		b.WriteString(span.Text)
+	}
+
+	return b.String()
+}
+
+// ANSI returns a text colored spans with ANSI escape sequences
+func ANSI(spans []*Span) string {
+	var b strings.Builder
+	for _, span := range spans {
+		var params []string
+
+		if span.Style&StyleBold != 0 {
+			params = append(params, "1")
+		}
+
+		if span.Style&StyleItalic != 0 {
+			params = append(params, "3")
+		}
+
+		if span.Style&StyleUnderline != 0 {
+			params = append(params, "4")
+		}
+
+		if span.Style&StyleStrikethrough != 0 {
+			params = append(params, "9")
+		}
+
+		if span.Color != NoColor {
+			params = append(params, fmt.Sprintf("38;2;%d;%d;%d",
+				(span.Color>>16)&0xff, (span.Color>>8)&0xff, span.Color&0xff))
+		}
+
+		if len(params) > 0 {
+			b.WriteString("\x1b[" + strings.Join(params, ";") + "m")
+			b.WriteString(span.Text)
+			b.WriteString("\x1b[0m")
+		} else {
+			b.WriteString(span.Text)
+		}
+	}
+
+	return b.String()
+}
+
+func (p *parser) minecraft(code rune) {
+	code = []rune(strings.ToLower(string(code)))[0]
+
+	if color, ok := MinecraftColors[code]; ok { // a color resets styles
+		p.set(color, 0)
+		return
+	}
+
+	if style, ok := MinecraftStyles[code]; ok {
+		p.set(p.color, p.style|style)
+		return
+	}
+
+	if code == 'r' {
+		p.set(NoColor, 0)
+	}
+}
+
+// escape skips an escape sequence starting at i and returns the last index of it
+func (p *parser) escape(runes []rune, i int) int {
+	if i+1 >= len(runes) {
+		return i
+	}
+
+	switch runes[i+1] {
+	case '[': // CSI
+		for j := i + 2; j < len(runes); j++ {
+			if runes[j] >= 0x40 && runes[j] <= 0x7e { // final byte
+				if runes[j] == 'm' {
+					p.sgr(string(runes[i+2 : j]))
+				}
+
+				return j
+			}
+		}
+
+		return len(runes) - 1
+	case ']': // OSC, ends with BEL or ESC \
+		for j := i + 2; j < len(runes); j++ {
+			if runes[j] == bell {
+				return j
+			}
+
+			if runes[j] == escape && j+1 < len(runes) && runes[j+1] == '\\' {
+				return j + 1
+			}
+		}
+
+		return len(runes) - 1
+	}
+
+	return i + 1
+}
+
+// sgr applies Select Graphic Rendition parameters
+func (p *parser) sgr(params string) {
+	if len(params) == 0 {
+		params = "0"
+	}
+
+	args := strings.Split(params, ";")
+
+	color, style := p.color, p.style
+	for i := 0; i < len(args); i++ {
+		n, err := strconv.Atoi(args[i])
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case n == 0:
+			color, style = NoColor, 0
+		case n == 1:
+			style |= StyleBold
+		case n == 3:
+			style |= StyleItalic
+		case n == 4:
+			style |= StyleUnderline
+		case n == 9:
+			style |= StyleStrikethrough
+		case n == 22:
+			style &^= StyleBold
+		case n == 23:
+			style &^= StyleItalic
+		case n == 24:
+			style &^= StyleUnderline
+		case n == 29:
+			style &^= StyleStrikethrough
+		case n >= 30 && n <= 37:
+			color = ANSIColors[n-30]
+		case n >= 90 && n <= 97:
+			color = ANSIColors[n-90+8]
+		case n == 39:
+			color = NoColor
+		case n == 38 || n == 48: // extended colors
+			c, skip := extendedColor(args[i+1:])
+			if n == 38 && c != NoColor {
+				color = c
+			}
+
+			i += skip
+		}
+	}
+
+	p.set(color, style)
+}
+
+// extendedColor parses 5;n or 2;r;g;b
+// It returns a color and the count of used args
+func extendedColor(args []string) (int32, int) {
+	if len(args) == 0 {
+		return NoColor, 0
+	}
+
+	nums := make([]int32, 0, 4)
+	for _, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 || n > 255 {
+			n = 0
+		}
+
+		nums = append(nums, int32(n))
+	}
+
+	switch nums[0] {
+	case 5:
+		if len(nums) < 2 {
+			return NoColor, len(nums)
+		}
+
+		return color256(nums[1]), 2
+	case 2:
+		if len(nums) < 4 {
+			return NoColor, len(nums)
+		}
+
+		return nums[1]<<16 | nums[2]<<8 | nums[3], 4
+	}
+
+	return NoColor, 1
+}
+
+// color256 returns a color of the 256 colors palette
+func color256(n int32) int32 {
+	switch {
+	case n < 16:
+		return ANSIColors[n]
+	case n < 232: // 6x6x6 cube
+		levels := [6]int32{0, 95, 135, 175, 215, 255}
+		n -= 16
+
+		return levels[n/36]<<16 | levels[(n/6)%6]<<8 | levels[n%6]
+	}
+
+	gray := 8 + (n-232)*10
+
+	return gray<<16 | gray<<8 | gray
+}
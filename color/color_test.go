@@ -0,0 +1,60 @@
+package color
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line string
+		want []*Span
+	}{
+		{"", nil},
+		{"plain", []*Span{{"plain", NoColor, 0}}},
+		{"§aHi §lthere", []*Span{{"Hi ", 0x55FF55, 0}, {"there", 0x55FF55, StyleBold}}},
+		{"§l§cRed", []*Span{{"Red", 0xFF5555, 0}}}, // a color resets styles
+		{"§Aupper§r reset", []*Span{{"upper", 0x55FF55, 0}, {" reset", NoColor, 0}}},
+		{"\x1b[31mred\x1b[0m", []*Span{{"red", 0xAA0000, 0}}},
+		{"\x1b[1;92mbold\x1b[22m green", []*Span{{"bold", 0x55FF55, StyleBold}, {" green", 0x55FF55, 0}}},
+		{"\x1b[38;5;196mx", []*Span{{"x", 0xFF0000, 0}}},
+		{"\x1b[38;2;1;2;3mx", []*Span{{"x", 0x010203, 0}}},
+		{"\x1b[48;5;1mbg", []*Span{{"bg", NoColor, 0}}},
+		{"\x1b]0;title\x07text\x1b[K", []*Span{{"text", NoColor, 0}}},
+		{"a\rb\tc", []*Span{{"ab\tc", NoColor, 0}}},
+		{"end§", []*Span{{"end§", NoColor, 0}}},
+	}
+
+	for _, test := range tests {
+		got := Parse(test.line)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Parse(%q) = %s, want %s", test.line, spansString(got), spansString(test.want))
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	got := Strip("\x1b[32m§aDone\x1b[0m (0.3s)!")
+	if got != "Done (0.3s)!" {
+		t.Errorf("Strip() = %q", got)
+	}
+}
+
+func spansString(spans []*Span) string {
+	str := "["
+	for _, span := range spans {
+		str += fmt.Sprintf(" %+v", *span)
+	}
+
+	return str + " ]"
+}
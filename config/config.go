@@ -9,9 +9,67 @@ package config
  * http://opensource.org/licenses/mit-license.php
 **/
 
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	DefaultPort    = 8080
+	DefaultDataDir = "data"
+)
+
+// Load reads a toml file and returns the config
+func Load(path string) (*Config, error) {
+	conf := &Config{
+		Server: ServerConfig{
+			Port:    DefaultPort,
+			DataDir: DefaultDataDir,
+		},
+	}
+
+	_, err := toml.DecodeFile(path, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Server.Port <= 0 || conf.Server.Port > 65535 {
+		return nil, errors.New("invalid port in server config")
+	}
+
+	conf.Server.DataDir = resolve(path, conf.Server.DataDir)
+
+	if len(conf.Server.UsersFile) > 0 {
+		file := resolve(path, conf.Server.UsersFile)
+
+		users := &UsersConfig{}
+
+		_, err = toml.DecodeFile(file, users)
+		if err != nil {
+			return nil, err
+		}
+
+		conf.Users = append(conf.Users, users.Users...)
+	}
+
+	return conf, nil
+}
+
+// resolve returns file relative to the directory of the config file at path
+func resolve(path string, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+
+	return filepath.Join(filepath.Dir(path), file)
+}
+
 type Config struct {
 	Server      ServerConfig      `toml:"server"`
 	Programs    []ProgramConfig   `toml:"programs"`
+	Users       []UserConfig      `toml:"users"`
 	Development DevelopmentConfig `toml:"dev"`
 }
 
@@ -20,6 +78,28 @@ type ServerConfig struct {
 	Port  int    `toml:"port"`
 	UUID  string `toml:"uuid"`
 	Debug bool   `toml:"debug"`
+
+	// DataDir is a directory saving logs etc., relative to the config file
+	DataDir string `toml:"data_dir"`
+
+	// UsersFile is a toml file having users, relative to the config file
+	UsersFile string `toml:"users_file"`
+
+	// TokenRole is a role of the token, admin by default
+	// If users are configured, the token is disabled unless it's set
+	TokenRole string `toml:"token_role"`
+}
+
+// UsersConfig is a config of a users file
+type UsersConfig struct {
+	Users []UserConfig `toml:"users"`
+}
+
+// UserConfig is a config of a user account
+type UserConfig struct {
+	Name     string `toml:"name"`
+	Password string `toml:"password"` // a bcrypt hash
+	Role     string `toml:"role"`     // viewer, operator or admin
 }
 
 type ProgramConfig struct {
@@ -27,6 +107,21 @@ type ProgramConfig struct {
 	Path          string            `toml:"path"`
 	Loader        string            `toml:"loader"`
 	LoaderOptions map[string]string `toml:"loader_options"`
+	StopTimeout   int               `toml:"stop_timeout"`  // seconds
+	Restart       string            `toml:"restart"`       // never, on-failure or always
+	MaxRetries    *int              `toml:"max_retries"`   // 5 if unset, 0 for no retries, negative for unlimited
+	RestartDelay  int               `toml:"restart_delay"` // seconds, doubled for each retry
+	LockFile      bool              `toml:"lock_file"`     // takes mimi.lock in the program directory
+	Detached      bool              `toml:"detached"`      // keeps running after mimi exited
+	Log           LogConfig         `toml:"log"`
+}
+
+// LogConfig is a config of log files of a program
+// Files are saved in <data dir>/logs/<program>/<date>.log
+type LogConfig struct {
+	Enabled bool `toml:"enabled"`
+	MaxSize int  `toml:"max_size"` // megabytes of a file before rotated
+	MaxAge  int  `toml:"max_age"`  // days to keep old files, 0 keeps them forever
 }
 
 type DevelopmentConfig struct {
@@ -0,0 +1,58 @@
+package config
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPaths(t *testing.T) {
+	dir := t.TempDir()
+
+	abs := filepath.Join(t.TempDir(), "data")
+
+	tests := []struct {
+		server  string
+		dataDir string
+	}{
+		{"", filepath.Join(dir, DefaultDataDir)},
+		{`data_dir = "mimi/data"`, filepath.Join(dir, "mimi", "data")},
+		{`data_dir = '` + abs + `'`, abs},
+	}
+
+	err := ioutil.WriteFile(filepath.Join(dir, "users.toml"), []byte("[[users]]\nname = \"alice\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, "config.toml")
+
+		err := ioutil.WriteFile(path, []byte("[server]\nusers_file = \"users.toml\"\n"+test.server+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conf, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if conf.Server.DataDir != test.dataDir {
+			t.Errorf("DataDir with %q = %s, want %s", test.server, conf.Server.DataDir, test.dataDir)
+		}
+
+		if len(conf.Users) != 1 || conf.Users[0].Name != "alice" {
+			t.Errorf("Users with %q = %+v", test.server, conf.Users)
+		}
+	}
+}
@@ -17,6 +17,10 @@ const (
 	ErrIDConsoleNotFound
 	ErrIDConsoleAlreadyClosed
 	ErrIDSessionNotJoinedConsole
+	ErrIDConsoleBusy
+	ErrIDNoExitStatus
+	ErrIDInvalidQuery
+	ErrIDPermissionDenied
 )
 
 type ErrorMessage struct {
@@ -24,6 +28,25 @@ type ErrorMessage struct {
 	Message string
 }
 
+func (e *ErrorMessage) Error() string {
+	return e.Message
+}
+
+// GetError returns a ErrorMessage for id
+// If id is unknown, it returns a ErrorMessage with a generic message
+func GetError(id int) *ErrorMessage {
+	for _, e := range Errors {
+		if e.ID == id {
+			return e
+		}
+	}
+
+	return &ErrorMessage{
+		ID:      id,
+		Message: "Unknown error",
+	}
+}
+
 var (
 	ErrJaga = &ErrorMessage{
 		ID:      ErrIDJaga,
@@ -53,4 +76,34 @@ var (
 		ID:      ErrIDSessionNotJoinedConsole,
 		Message: "A session doesn't join a console",
 	}
+	ErrConsoleBusy = &ErrorMessage{
+		ID:      ErrIDConsoleBusy,
+		Message: "A console is busy, commands were dropped",
+	}
+	ErrNoExitStatus = &ErrorMessage{
+		ID:      ErrIDNoExitStatus,
+		Message: "A program hasn't exited yet",
+	}
+	ErrInvalidQuery = &ErrorMessage{
+		ID:      ErrIDInvalidQuery,
+		Message: "A search query is invalid",
+	}
+	ErrPermissionDenied = &ErrorMessage{
+		ID:      ErrIDPermissionDenied,
+		Message: "A user doesn't have permission",
+	}
 )
+
+var Errors = []*ErrorMessage{
+	ErrJaga,
+	ErrInternalError,
+	ErrProgramNotFound,
+	ErrProgramAlreadyRunning,
+	ErrConsoleNotFound,
+	ErrConsoleAlreadyClosed,
+	ErrSessionNotJoined,
+	ErrConsoleBusy,
+	ErrNoExitStatus,
+	ErrInvalidQuery,
+	ErrPermissionDenied,
+}
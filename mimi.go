@@ -14,14 +14,18 @@ import (
 )
 
 const (
-	Version         = "1.0.0"
-	ProtocolVersion = 1
+	Version            = "1.0.0"
+	ProtocolVersion    = 9 // changes of each version are listed in pks/protocol.go
+	MinProtocolVersion = 1
 )
 
 const (
+	StreamPath = "/stream"
 	QueryToken = "token" // ?token=jagajaga
 
 	HandshakeTimeout = 10 * time.Second
+	WriteTimeout     = 10 * time.Second
+	ShutdownTimeout  = 30 * time.Second
 )
 
 const (
@@ -30,6 +34,6 @@ const (
 
 	MaxProcessData = 20
 
-	UpdateInterval = 100 * time.Millisecond // 0.1
+	UpdateInterval        = 100 * time.Millisecond // 0.1
 	SessionUpdateInterval = UpdateInterval
 )
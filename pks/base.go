@@ -11,6 +11,7 @@ package pks
 
 import (
 	"errors"
+	"math"
 
 	uuid "github.com/satori/go.uuid"
 
@@ -35,6 +36,9 @@ type Packet interface {
 	// SetBytes sets bytes into buffer
 	SetBytes([]byte)
 
+	// SetProtocol sets a protocol version used to encode and decode
+	SetProtocol(byte)
+
 	// New returns new instance of the packet
 	New() Packet
 }
@@ -44,10 +48,20 @@ type BasePacket struct {
 	Packet
 
 	binary.Stream
+
+	protocol byte
+}
+
+// SetProtocol sets a protocol version used to encode and decode
+func (bpk *BasePacket) SetProtocol(protocol byte) {
+	bpk.protocol = protocol
 }
 
 // Encode encodes a packet
+// The buffer is reset, so a packet can be encoded again
 func (bpk *BasePacket) Encode(pk Packet) error {
+	bpk.Stream.SetBytes(nil)
+
 	err := bpk.PutByte(pk.ID())
 	if err != nil {
 		return err
@@ -68,9 +82,9 @@ func (bpk *BasePacket) Bytes() []byte {
 	return bpk.AllBytes()
 }
 
-// SetBytes returns encoded bytes
+// SetBytes sets bytes into buffer
 func (bpk *BasePacket) SetBytes(b []byte) {
-	bpk.SetBytes(b)
+	bpk.Stream.SetBytes(b)
 }
 
 // String reads a string from bytes
@@ -93,6 +107,9 @@ func (bpk *BasePacket) String() (string, error) {
 // Format: 2bytes(len) + xbytes(string)
 func (bpk *BasePacket) PutString(str string) error {
 	b := []byte(str)
+	if len(b) > math.MaxUint16 {
+		return errors.New("string is too long")
+	}
 
 	err := bpk.PutShort(uint16(len(b)))
 	if err != nil {
@@ -175,6 +192,13 @@ func (bpk *BasePacket) GetConsole() (con *Console, err error) {
 		return nil, err
 	}
 
+	if bpk.protocol >= 4 {
+		con.State, err = bpk.Byte()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return con, nil
 }
 
@@ -190,5 +214,226 @@ func (bpk *BasePacket) PutConsole(con *Console) error {
 		return err
 	}
 
+	if bpk.protocol >= 4 {
+		err = bpk.PutByte(con.State)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetSpan reads a span from buffer
+func (bpk *BasePacket) GetSpan() (span *Span, err error) {
+	span = &Span{}
+
+	span.Text, err = bpk.String()
+	if err != nil {
+		return nil, err
+	}
+
+	span.Color, err = bpk.Int()
+	if err != nil {
+		return nil, err
+	}
+
+	span.Style, err = bpk.Byte()
+	if err != nil {
+		return nil, err
+	}
+
+	return span, nil
+}
+
+// PutSpan writes a span to buffer
+func (bpk *BasePacket) PutSpan(span *Span) error {
+	err := bpk.PutString(span.Text)
+	if err != nil {
+		return err
+	}
+
+	err = bpk.PutInt(span.Color)
+	if err != nil {
+		return err
+	}
+
+	err = bpk.PutByte(span.Style)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetMessage reads a message from buffer
+func (bpk *BasePacket) GetMessage() (msg *Message, err error) {
+	msg = &Message{}
+
+	msg.Text, err = bpk.String()
+	if err != nil {
+		return nil, err
+	}
+
+	if bpk.protocol >= 2 {
+		msg.Stream, err = bpk.Byte()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if bpk.protocol >= 3 {
+		ln, err := bpk.Short()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < int(ln); i++ {
+			span, err := bpk.GetSpan()
+			if err != nil {
+				return nil, err
+			}
+
+			msg.Spans = append(msg.Spans, span)
+		}
+	}
+
+	if bpk.protocol >= 6 {
+		msg.Seq, err = bpk.Long()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if bpk.protocol >= 7 {
+		msg.Time, err = bpk.Long()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
+
+// PutMessage writes a message to buffer
+func (bpk *BasePacket) PutMessage(msg *Message) error {
+	err := bpk.PutString(msg.Text)
+	if err != nil {
+		return err
+	}
+
+	if bpk.protocol >= 2 {
+		err = bpk.PutByte(msg.Stream)
+		if err != nil {
+			return err
+		}
+	}
+
+	if bpk.protocol >= 3 {
+		err = bpk.PutShort(uint16(len(msg.Spans)))
+		if err != nil {
+			return err
+		}
+
+		for _, span := range msg.Spans {
+			err = bpk.PutSpan(span)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if bpk.protocol >= 6 {
+		err = bpk.PutLong(msg.Seq)
+		if err != nil {
+			return err
+		}
+	}
+
+	if bpk.protocol >= 7 {
+		err = bpk.PutLong(msg.Time)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetExit reads an exit record from buffer
+func (bpk *BasePacket) GetExit() (exit *Exit, err error) {
+	exit = &Exit{}
+
+	exit.Code, err = bpk.Int()
+	if err != nil {
+		return nil, err
+	}
+
+	exit.Signal, err = bpk.String()
+	if err != nil {
+		return nil, err
+	}
+
+	exit.Duration, err = bpk.Long()
+	if err != nil {
+		return nil, err
+	}
+
+	exit.Time, err = bpk.Long()
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := bpk.Short()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < int(ln); i++ {
+		msg, err := bpk.GetMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		exit.Lines = append(exit.Lines, msg)
+	}
+
+	return exit, nil
+}
+
+// PutExit writes an exit record to buffer
+func (bpk *BasePacket) PutExit(exit *Exit) error {
+	err := bpk.PutInt(exit.Code)
+	if err != nil {
+		return err
+	}
+
+	err = bpk.PutString(exit.Signal)
+	if err != nil {
+		return err
+	}
+
+	err = bpk.PutLong(exit.Duration)
+	if err != nil {
+		return err
+	}
+
+	err = bpk.PutLong(exit.Time)
+	if err != nil {
+		return err
+	}
+
+	err = bpk.PutShort(uint16(len(exit.Lines)))
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range exit.Lines {
+		err = bpk.PutMessage(msg)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
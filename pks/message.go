@@ -213,6 +213,9 @@ type ProgramStatus struct {
 	ProgramName string
 	ConsoleUUID uuid.UUID
 	Running     bool
+	State       byte  // protocol 4 or later, see ConsoleStarting etc.
+	Exit        *Exit // protocol 5 or later, only after the program exited
+	Restarting  bool  // protocol 9 or later, the program will be started again
 }
 
 func (ProgramStatus) ID() byte {
@@ -240,6 +243,34 @@ func (pk *ProgramStatus) Encode() error {
 		return err
 	}
 
+	if pk.protocol >= 4 {
+		err = pk.PutByte(pk.State)
+		if err != nil {
+			return err
+		}
+	}
+
+	if pk.protocol >= 5 {
+		err = pk.PutBool(pk.Exit != nil)
+		if err != nil {
+			return err
+		}
+
+		if pk.Exit != nil {
+			err = pk.PutExit(pk.Exit)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if pk.protocol >= 9 {
+		err = pk.PutBool(pk.Restarting)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -264,6 +295,34 @@ func (pk *ProgramStatus) Decode() error {
 		return err
 	}
 
+	if pk.protocol >= 4 {
+		pk.State, err = pk.Byte()
+		if err != nil {
+			return err
+		}
+	}
+
+	if pk.protocol >= 5 {
+		hasExit, err := pk.Bool()
+		if err != nil {
+			return err
+		}
+
+		if hasExit {
+			pk.Exit, err = pk.GetExit()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if pk.protocol >= 9 {
+		pk.Restarting, err = pk.Bool()
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -367,11 +426,14 @@ func (ResponseConsoleList) New() Packet {
 }
 
 // JoinConsole is a packet joining a console
+// Format is a format of messages sent by the console since protocol 3
 // Client -> Server
 type JoinConsole struct {
 	BasePacket
 
 	ConsoleUUID uuid.UUID
+	Format      byte
+	Lines       uint16 // protocol 6 or later, the number of last lines sent on joining
 }
 
 func (JoinConsole) ID() byte {
@@ -389,6 +451,20 @@ func (pk *JoinConsole) Encode() error {
 		return err
 	}
 
+	if pk.protocol >= 3 {
+		err = pk.PutByte(pk.Format)
+		if err != nil {
+			return err
+		}
+	}
+
+	if pk.protocol >= 6 {
+		err = pk.PutShort(pk.Lines)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -403,6 +479,20 @@ func (pk *JoinConsole) Decode() error {
 		return err
 	}
 
+	if pk.protocol >= 3 {
+		pk.Format, err = pk.Byte()
+		if err != nil {
+			return err
+		}
+	}
+
+	if pk.protocol >= 6 {
+		pk.Lines, err = pk.Short()
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -455,12 +545,13 @@ func (QuitConsole) New() Packet {
 }
 
 // ConsoleMessages is a packet sent a console message by server to a client joing the console
+// Streams of messages are sent since protocol 2, spans are sent since protocol 3
 // Server -> Client
 type ConsoleMessages struct {
 	BasePacket
 
 	MessagesLen byte
-	Messages    []string // older sorted
+	Messages    []*Message // older sorted
 }
 
 func (ConsoleMessages) ID() byte {
@@ -479,7 +570,7 @@ func (pk *ConsoleMessages) Encode() error {
 	}
 
 	for _, msg := range pk.Messages {
-		err = pk.PutString(msg)
+		err = pk.PutMessage(msg)
 		if err != nil {
 			return err
 		}
@@ -500,7 +591,7 @@ func (pk *ConsoleMessages) Decode() error {
 	}
 
 	for i := 0; i < int(pk.MessagesLen); i++ {
-		msg, err := pk.String()
+		msg, err := pk.GetMessage()
 		if err != nil {
 			return err
 		}
@@ -575,3 +666,553 @@ func (pk *SendCommands) Decode() error {
 func (SendCommands) New() Packet {
 	return new(SendCommands)
 }
+
+// ResizeConsole is a packet changing the window size of a joined console
+// It only affects consoles running on a pseudo-terminal
+// Client -> Server
+type ResizeConsole struct {
+	BasePacket
+
+	ConsoleUUID uuid.UUID
+	Rows        uint16
+	Cols        uint16
+}
+
+func (ResizeConsole) ID() byte {
+	return IDResizeConsole
+}
+
+func (pk *ResizeConsole) Encode() error {
+	err := pk.BasePacket.Encode(pk)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutUUID(pk.ConsoleUUID)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutShort(pk.Rows)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutShort(pk.Cols)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (pk *ResizeConsole) Decode() error {
+	err := pk.BasePacket.Decode(pk)
+	if err != nil {
+		return err
+	}
+
+	pk.ConsoleUUID, err = pk.GetUUID()
+	if err != nil {
+		return err
+	}
+
+	pk.Rows, err = pk.Short()
+	if err != nil {
+		return err
+	}
+
+	pk.Cols, err = pk.Short()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (ResizeConsole) New() Packet {
+	return new(ResizeConsole)
+}
+
+// RequestExitStatus is a request packet for the last exit of a program
+// If it send, it will send ExitStatus packet back by server
+// Client -> Server
+type RequestExitStatus struct {
+	BasePacket
+
+	ProgramName string
+}
+
+func (RequestExitStatus) ID() byte {
+	return IDRequestExitStatus
+}
+
+func (pk *RequestExitStatus) Encode() error {
+	err := pk.BasePacket.Encode(pk)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutString(pk.ProgramName)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (pk *RequestExitStatus) Decode() error {
+	err := pk.BasePacket.Decode(pk)
+	if err != nil {
+		return err
+	}
+
+	pk.ProgramName, err = pk.String()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (RequestExitStatus) New() Packet {
+	return new(RequestExitStatus)
+}
+
+// ExitStatus is a response packet for RequestExitStatus packet
+// Server -> Client
+type ExitStatus struct {
+	BasePacket
+
+	ProgramName string
+	ConsoleUUID uuid.UUID
+	Exit        *Exit
+}
+
+func (ExitStatus) ID() byte {
+	return IDExitStatus
+}
+
+func (pk *ExitStatus) Encode() error {
+	err := pk.BasePacket.Encode(pk)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutString(pk.ProgramName)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutUUID(pk.ConsoleUUID)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutExit(pk.Exit)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (pk *ExitStatus) Decode() error {
+	err := pk.BasePacket.Decode(pk)
+	if err != nil {
+		return err
+	}
+
+	pk.ProgramName, err = pk.String()
+	if err != nil {
+		return err
+	}
+
+	pk.ConsoleUUID, err = pk.GetUUID()
+	if err != nil {
+		return err
+	}
+
+	pk.Exit, err = pk.GetExit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (ExitStatus) New() Packet {
+	return new(ExitStatus)
+}
+
+// RequestConsoleHistory is a request packet for Count lines of a console before Seq
+// If Seq is 0, it requests the last lines. If Count is 0, the server decides the number
+// If it send, it's sent a ConsoleHistory packet back
+// Client -> Server
+type RequestConsoleHistory struct {
+	BasePacket
+
+	ConsoleUUID uuid.UUID
+	Seq         int64
+	Count       uint16
+	Format      byte
+}
+
+func (RequestConsoleHistory) ID() byte {
+	return IDRequestConsoleHistory
+}
+
+func (pk *RequestConsoleHistory) Encode() error {
+	err := pk.BasePacket.Encode(pk)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutUUID(pk.ConsoleUUID)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutLong(pk.Seq)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutShort(pk.Count)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutByte(pk.Format)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (pk *RequestConsoleHistory) Decode() error {
+	err := pk.BasePacket.Decode(pk)
+	if err != nil {
+		return err
+	}
+
+	pk.ConsoleUUID, err = pk.GetUUID()
+	if err != nil {
+		return err
+	}
+
+	pk.Seq, err = pk.Long()
+	if err != nil {
+		return err
+	}
+
+	pk.Count, err = pk.Short()
+	if err != nil {
+		return err
+	}
+
+	pk.Format, err = pk.Byte()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (RequestConsoleHistory) New() Packet {
+	return new(RequestConsoleHistory)
+}
+
+// ConsoleHistory is a response packet for RequestConsoleHistory packet
+// More is true if older lines may be left
+// Server -> Client
+type ConsoleHistory struct {
+	BasePacket
+
+	ConsoleUUID uuid.UUID
+	MessagesLen uint16
+	Messages    []*Message // older sorted
+	More        bool
+}
+
+func (ConsoleHistory) ID() byte {
+	return IDConsoleHistory
+}
+
+func (pk *ConsoleHistory) Encode() error {
+	err := pk.BasePacket.Encode(pk)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutUUID(pk.ConsoleUUID)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutShort(uint16(len(pk.Messages)))
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range pk.Messages {
+		err = pk.PutMessage(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = pk.PutBool(pk.More)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (pk *ConsoleHistory) Decode() error {
+	err := pk.BasePacket.Decode(pk)
+	if err != nil {
+		return err
+	}
+
+	pk.ConsoleUUID, err = pk.GetUUID()
+	if err != nil {
+		return err
+	}
+
+	pk.MessagesLen, err = pk.Short()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < int(pk.MessagesLen); i++ {
+		msg, err := pk.GetMessage()
+		if err != nil {
+			return err
+		}
+
+		pk.Messages = append(pk.Messages, msg)
+	}
+
+	pk.More, err = pk.Bool()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (ConsoleHistory) New() Packet {
+	return new(ConsoleHistory)
+}
+
+// RequestSearch is a request packet searching lines of a console
+// The last MaxResults lines containing Query (or matching it if Regexp is true) are searched
+// If MaxResults is 0, the server decides the number
+// Since and Until are unix time, 0 for no limit
+// If it send, it's sent a SearchResult packet back
+// Client -> Server
+type RequestSearch struct {
+	BasePacket
+
+	ConsoleUUID uuid.UUID
+	Query       string
+	Regexp      bool
+	Since       int64
+	Until       int64
+	Stream      byte // StreamAll for all streams
+	MaxResults  uint16
+	Format      byte
+}
+
+func (RequestSearch) ID() byte {
+	return IDRequestSearch
+}
+
+func (pk *RequestSearch) Encode() error {
+	err := pk.BasePacket.Encode(pk)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutUUID(pk.ConsoleUUID)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutString(pk.Query)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutBool(pk.Regexp)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutLong(pk.Since)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutLong(pk.Until)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutByte(pk.Stream)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutShort(pk.MaxResults)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutByte(pk.Format)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (pk *RequestSearch) Decode() error {
+	err := pk.BasePacket.Decode(pk)
+	if err != nil {
+		return err
+	}
+
+	pk.ConsoleUUID, err = pk.GetUUID()
+	if err != nil {
+		return err
+	}
+
+	pk.Query, err = pk.String()
+	if err != nil {
+		return err
+	}
+
+	pk.Regexp, err = pk.Bool()
+	if err != nil {
+		return err
+	}
+
+	pk.Since, err = pk.Long()
+	if err != nil {
+		return err
+	}
+
+	pk.Until, err = pk.Long()
+	if err != nil {
+		return err
+	}
+
+	pk.Stream, err = pk.Byte()
+	if err != nil {
+		return err
+	}
+
+	pk.MaxResults, err = pk.Short()
+	if err != nil {
+		return err
+	}
+
+	pk.Format, err = pk.Byte()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (RequestSearch) New() Packet {
+	return new(RequestSearch)
+}
+
+// SearchResult is a response packet for RequestSearch packet
+// More is true if older lines may match
+// Server -> Client
+type SearchResult struct {
+	BasePacket
+
+	ConsoleUUID uuid.UUID
+	MessagesLen uint16
+	Messages    []*Message // older sorted
+	More        bool
+}
+
+func (SearchResult) ID() byte {
+	return IDSearchResult
+}
+
+func (pk *SearchResult) Encode() error {
+	err := pk.BasePacket.Encode(pk)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutUUID(pk.ConsoleUUID)
+	if err != nil {
+		return err
+	}
+
+	err = pk.PutShort(uint16(len(pk.Messages)))
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range pk.Messages {
+		err = pk.PutMessage(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = pk.PutBool(pk.More)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (pk *SearchResult) Decode() error {
+	err := pk.BasePacket.Decode(pk)
+	if err != nil {
+		return err
+	}
+
+	pk.ConsoleUUID, err = pk.GetUUID()
+	if err != nil {
+		return err
+	}
+
+	pk.MessagesLen, err = pk.Short()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < int(pk.MessagesLen); i++ {
+		msg, err := pk.GetMessage()
+		if err != nil {
+			return err
+		}
+
+		pk.Messages = append(pk.Messages, msg)
+	}
+
+	pk.More, err = pk.Bool()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (SearchResult) New() Packet {
+	return new(SearchResult)
+}
@@ -9,6 +9,23 @@ package pks
  * http://opensource.org/licenses/mit-license.php
 **/
 
+// Fields and packets added by each protocol version
+// Fields added later are encoded only if both sides use the version or later
+//
+//	1 the first version
+//	2 Message.Stream, ResizeConsole
+//	3 JoinConsole.Format, Message.Spans
+//	4 Console.State, ProgramStatus.State
+//	5 ProgramStatus.Exit, RequestExitStatus, ExitStatus
+//	6 JoinConsole.Lines, Message.Seq, RequestConsoleHistory, ConsoleHistory,
+//	  RequestSearch, SearchResult
+//	7 Message.Time
+//	8 ErrorMessage.Request
+//	9 ProgramStatus.Restarting
+//
+// ResizeConsole and RequestSearch were added after their version was released,
+// so early servers of protocol 2 and 6 may not know them
+
 const (
 	IDConnectionOne = iota
 	IDConnectionRequest
@@ -28,6 +45,13 @@ const (
 	IDQuitConsole
 	IDConsoleMessages
 	IDSendCommands
+	IDResizeConsole
+	IDRequestExitStatus
+	IDExitStatus
+	IDRequestConsoleHistory
+	IDConsoleHistory
+	IDRequestSearch
+	IDSearchResult
 )
 
 var Protocol = map[byte]Packet{
@@ -47,8 +71,15 @@ var Protocol = map[byte]Packet{
 	IDResponseConsoleList:       &ResponseConsoleList{},
 	IDJoinConsole:               &JoinConsole{},
 	IDQuitConsole:               &QuitConsole{},
-	IDConsoleMessages:            &ConsoleMessages{},
+	IDConsoleMessages:           &ConsoleMessages{},
 	IDSendCommands:              &SendCommands{},
+	IDResizeConsole:             &ResizeConsole{},
+	IDRequestExitStatus:         &RequestExitStatus{},
+	IDExitStatus:                &ExitStatus{},
+	IDRequestConsoleHistory:     &RequestConsoleHistory{},
+	IDConsoleHistory:            &ConsoleHistory{},
+	IDRequestSearch:             &RequestSearch{},
+	IDSearchResult:              &SearchResult{},
 }
 
 // GetPacket returns a packet registered by Protocol
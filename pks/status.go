@@ -46,7 +46,8 @@ func (DisconnectionNotification) New() Packet {
 type ErrorMessage struct {
 	BasePacket
 
-	Error int `json:"error"`
+	Error   int  `json:"error"`
+	Request byte `json:"request"` // ID of a packet causing the error, protocol 8 or later
 }
 
 func (ErrorMessage) ID() byte {
@@ -64,6 +65,13 @@ func (pk *ErrorMessage) Encode() error {
 		return err
 	}
 
+	if pk.protocol >= 8 {
+		err = pk.PutByte(pk.Request)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -80,6 +88,13 @@ func (pk *ErrorMessage) Decode() error {
 
 	pk.Error = int(e)
 
+	if pk.protocol >= 8 {
+		pk.Request, err = pk.Byte()
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -21,4 +21,71 @@ type Program struct {
 type Console struct {
 	UUID    uuid.UUID
 	Program *Program
+	State   byte // protocol 4 or later
+}
+
+// States of a console
+const (
+	ConsoleStarting byte = iota // the program is started, but not ready yet
+	ConsoleReady                // the program is ready
+	ConsoleStopping             // the program is being stopped
+	ConsoleStopped              // the program has exited
+	ConsoleCrashed              // the program has exited unexpectedly
+)
+
+var consoleStateNames = map[byte]string{
+	ConsoleStarting: "starting",
+	ConsoleReady:    "ready",
+	ConsoleStopping: "stopping",
+	ConsoleStopped:  "stopped",
+	ConsoleCrashed:  "crashed",
+}
+
+// ConsoleStateName returns a name of a console state
+func ConsoleStateName(state byte) string {
+	name, ok := consoleStateNames[state]
+	if !ok {
+		return "unknown"
+	}
+
+	return name
+}
+
+const (
+	StreamStdout byte = iota
+	StreamStderr
+	StreamDropped // a marker of lines dropped for a slow client, Seq is the first dropped line
+
+	StreamAll byte = 0xff // all streams, used for filters
+)
+
+const (
+	FormatRaw   byte = iota // as written by a program
+	FormatPlain             // without color codes
+	FormatSpans             // plain text with styled spans
+)
+
+// Message is a line of a console
+type Message struct {
+	Text   string
+	Stream byte    // protocol 2 or later
+	Spans  []*Span // protocol 3 or later, only for FormatSpans
+	Seq    int64   // protocol 6 or later, sequence number in the console
+	Time   int64   // protocol 7 or later, unix time in milliseconds when the line was printed
+}
+
+// Span is a styled text in a message
+type Span struct {
+	Text  string
+	Color int32 // 0xRRGGBB or -1 (default)
+	Style byte  // see color.Style
+}
+
+// Exit is a record of an exited program
+type Exit struct {
+	Code     int32  // -1 if killed by a signal
+	Signal   string // empty if exited by itself
+	Duration int64  // running time in milliseconds
+	Time     int64  // unix time when exited
+	Lines    []*Message
 }
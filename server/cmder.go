@@ -10,101 +10,470 @@ package server
 **/
 
 import (
-	"bufio"
+	"bytes"
+	"errors"
 	"io"
+	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/beito123/mimi/pks"
+)
+
+const (
+	// KillTimeout is time to wait for a process after SIGTERM before SIGKILL
+	KillTimeout = 5 * time.Second
+
+	MaxSendStack = 64
+
+	// MaxLineLength is length of a line in bytes at most, longer lines are split
+	MaxLineLength = 16 * 1024
+
+	// ReadBufferSize is size of output read at once
+	ReadBufferSize = 4096
+)
+
+// Line is a line written by a process
+type Line struct {
+	Seq    int64     // sequence number in the console, numbered by LogStacker
+	Time   time.Time // when the console received the line
+	Stream byte      // pks.StreamStdout or pks.StreamStderr
+	Text   string
+}
+
+// Exit is a record of an exited process
+type Exit struct {
+	Code     int    // -1 if killed by a signal
+	Signal   string // empty if exited by itself
+	Duration time.Duration
+	Time     time.Time
+	Lines    []*Line // last lines of the console
+}
+
+// newExit returns a record from state of a process started at start
+func newExit(state *os.ProcessState, start time.Time) *Exit {
+	exit := &Exit{
+		Code:     -1,
+		Duration: time.Since(start),
+		Time:     time.Now(),
+	}
+
+	if state == nil {
+		return exit
+	}
+
+	exit.Code = state.ExitCode()
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		exit.Signal = status.Signal().String()
+	}
+
+	return exit
+}
+
+var (
+	errNotRunning = errors.New("the process isn't running")
+	errSendFull   = errors.New("too many commands are queued")
+	errNotPTY     = errors.New("the process isn't running on a pseudo-terminal")
+)
+
+const (
+	DefaultPTYRows = 24
+	DefaultPTYCols = 80
 )
 
 type Cmder struct {
 	WorkingDir string
+	Env        []string // added to the environment of mimi
+	PTY        bool     // runs the process under a pseudo-terminal
+	Detached   bool     // runs the process surviving mimi, see cmder_detach.go
+
+	process *os.Process
+	stdin   io.WriteCloser
+	tty     *os.File // master of a pseudo-terminal
+
+	stdinMutex sync.Mutex
 
-	lineCh chan string
-	errCh  chan error
-	sendCh chan string
+	lineCh    chan *Line
+	sendCh    chan string
+	doneCh    chan bool
+	releaseCh chan bool
+
+	exitErr  error
+	exit     *Exit
+	released bool
+	offsets  DetachOffsets // of output read from a detached process
+}
+
+func (cmder *Cmder) init() {
+	cmder.lineCh = make(chan *Line, 10)
+	cmder.sendCh = make(chan string, MaxSendStack)
+	cmder.doneCh = make(chan bool)
+	cmder.releaseCh = make(chan bool)
 }
 
 func (cmder *Cmder) Start(program string, param ...string) error {
-	cmder.lineCh = make(chan string, 10)
-	cmder.sendCh = make(chan string, 10)
-	cmder.errCh = make(chan error, 1)
+	cmder.init()
 
 	cmd := exec.Command(program, param...)
 
 	cmd.Dir = cmder.WorkingDir
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
+	if len(cmder.Env) > 0 {
+		cmd.Env = append(os.Environ(), cmder.Env...)
 	}
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return err
+	if cmder.Detached {
+		return cmder.startDetached(cmd)
 	}
 
-	/* TODO:
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return err
-	}
-	*/
+	var wg sync.WaitGroup
+
+	if cmder.PTY {
+		tty, err := startPTY(cmd, DefaultPTYRows, DefaultPTYCols)
+		if err != nil {
+			return err
+		}
+
+		cmder.tty = tty
+		cmder.stdin = tty
+
+		// stdout and stderr are merged by the terminal
+		wg.Add(1)
+		go cmder.scan(&wg, tty, pks.StreamStdout)
+	} else {
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+
+		cmder.stdin = stdin
 
-	err = cmd.Start()
-	if err != nil {
-		return err
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			return err
+		}
+
+		err = cmd.Start()
+		if err != nil {
+			return err
+		}
+
+		wg.Add(2)
+		go cmder.scan(&wg, stdout, pks.StreamStdout)
+		go cmder.scan(&wg, stderr, pks.StreamStderr)
 	}
 
+	cmder.process = cmd.Process
+
+	startTime := time.Now()
+
 	go func() {
 		defer cmder.close()
 
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			cmder.lineCh <- scanner.Text()
-		}
+		wg.Wait() // Wait must be called after reading pipes
 
-		err = cmd.Wait()
-		if err != nil {
-			cmder.errCh <- err
+		cmder.exitErr = cmd.Wait()
+		cmder.exit = newExit(cmd.ProcessState, startTime)
+
+		if cmder.tty != nil {
+			cmder.tty.Close()
 		}
 	}()
 
-	go func() {
+	go cmder.sender()
+
+	return nil
+}
+
+// sender writes queued lines to stdin until the process exits
+func (cmder *Cmder) sender() {
+	for {
+		var str string
+
 		select {
-		case str, ok := <-cmder.sendCh:
-			if ok {
-				return
+		case <-cmder.doneCh:
+			return
+		case str = <-cmder.sendCh:
+		}
+
+		err := cmder.write(str)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// scan reads lines from r and sends them tagged with stream
+// It reads until r is closed, so the process doesn't block on writing
+func (cmder *Cmder) scan(wg *sync.WaitGroup, r io.Reader, stream byte) {
+	defer wg.Done()
+
+	var lines lineBuffer
+
+	buf := make([]byte, ReadBufferSize)
+	for {
+		n, err := r.Read(buf)
+
+		for _, text := range lines.add(buf[:n]) {
+			cmder.send(stream, text)
+		}
+
+		if err != nil {
+			// a pseudo-terminal returns EIO after the process exited
+			if err != io.EOF && !errors.Is(err, syscall.EIO) && !errors.Is(err, os.ErrClosed) {
+				logger.Errorf("Couldn't read output of a process: %s", err.Error())
 			}
 
-			io.WriteString(stdin, str)
+			break
 		}
-	}()
+	}
+
+	if text, ok := lines.flush(); ok {
+		cmder.send(stream, text)
+	}
+}
+
+// send sends a line read from the process
+func (cmder *Cmder) send(stream byte, text string) {
+	cmder.lineCh <- &Line{
+		Stream: stream,
+		Text:   text,
+	}
+}
+
+// lineBuffer splits output into lines without line breaks
+// Lines longer than MaxLineLength are split, a packet can't have a string over 65535 bytes
+type lineBuffer struct {
+	text []byte
+}
+
+// add appends b and returns lines completed
+func (lb *lineBuffer) add(b []byte) []string {
+	lb.text = append(lb.text, b...)
+
+	var lines []string
+	for {
+		i := bytes.IndexByte(lb.text, '\n')
+		if i < 0 && len(lb.text) <= MaxLineLength {
+			break
+		}
+
+		var line []byte
+		if i >= 0 && i <= MaxLineLength {
+			line = lb.text[:i]
+			lb.text = lb.text[i+1:]
+		} else {
+			n := cutIndex(lb.text, MaxLineLength)
+
+			line = lb.text[:n]
+			lb.text = lb.text[n:]
+		}
+
+		// terminals end lines with \r\n
+		lines = append(lines, string(bytes.TrimSuffix(line, []byte("\r"))))
+	}
+
+	return lines
+}
+
+// flush returns text without a line break at the end
+func (lb *lineBuffer) flush() (string, bool) {
+	if len(lb.text) == 0 {
+		return "", false
+	}
+
+	text := string(bytes.TrimSuffix(lb.text, []byte("\r")))
+	lb.text = nil
+
+	return text, true
+}
+
+// empty returns whether no text is left
+func (lb *lineBuffer) empty() bool {
+	return len(lb.text) == 0
+}
+
+// cutIndex returns n or less not to split a UTF-8 character
+func cutIndex(b []byte, n int) int {
+	for i := n; i > 0 && i > n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			return i
+		}
+	}
+
+	return n
+}
+
+// Stop stops the process gracefully
+// It sends command to the process and waits for timeout,
+// then sends SIGTERM and SIGKILL if it's still running
+func (cmder *Cmder) Stop(command string, timeout time.Duration) error {
+	if !cmder.Running() {
+		return errNotRunning
+	}
+
+	if len(command) > 0 {
+		err := cmder.write(command + "\n")
+		if err == nil && cmder.wait(timeout) {
+			return nil
+		}
+	}
+
+	err := cmder.process.Signal(syscall.SIGTERM)
+	if err == nil && cmder.wait(KillTimeout) {
+		return nil
+	}
+
+	cmder.Close()
+
+	<-cmder.doneCh
 
 	return nil
 }
 
+// Running returns whether the process is running
+func (cmder *Cmder) Running() bool {
+	if cmder.doneCh == nil {
+		return false
+	}
+
+	select {
+	case <-cmder.doneCh:
+		return false
+	default:
+	}
+
+	return true
+}
+
+// Done returns a channel closed after the process exited
+func (cmder *Cmder) Done() <-chan bool {
+	return cmder.doneCh
+}
+
+// Err returns an error of the exited process, or nil if it exited successfully
+// It's valid after Done is closed
+func (cmder *Cmder) Err() error {
+	return cmder.exitErr
+}
+
+// Exit returns a record of the exited process
+// It's valid after Done is closed
+func (cmder *Cmder) Exit() *Exit {
+	return cmder.exit
+}
+
+// Close kills the process
+// The channels are closed after the process exited
 func (cmder *Cmder) Close() {
-	cmder.close()
+	if cmder.process == nil {
+		return
+	}
+
+	cmder.process.Kill()
+}
+
+// Release stops reading the process without stopping it
+// It's only for detached processes, which keep running after mimi exited
+func (cmder *Cmder) Release() {
+	if !cmder.Detached || !cmder.Running() {
+		return
+	}
+
+	select {
+	case <-cmder.releaseCh:
+	default:
+		close(cmder.releaseCh)
+	}
+
+	<-cmder.doneCh
+}
+
+// Released returns whether the process was released
+// It's valid after Done is closed
+func (cmder *Cmder) Released() bool {
+	return cmder.released
+}
+
+// Offsets returns offsets of output read from a detached process
+// It's valid after Done is closed
+func (cmder *Cmder) Offsets() *DetachOffsets {
+	offsets := cmder.offsets
+
+	return &offsets
+}
+
+// Pid returns a process id
+func (cmder *Cmder) Pid() int {
+	if cmder.process == nil {
+		return 0
+	}
+
+	return cmder.process.Pid
 }
 
 func (cmder *Cmder) close() {
-	close(cmder.errCh)
 	close(cmder.lineCh)
-	close(cmder.sendCh)
+	close(cmder.doneCh)
 }
 
-func (cmder *Cmder) Line() (string, bool) {
+// wait waits for the process to exit up to timeout
+func (cmder *Cmder) wait(timeout time.Duration) bool {
 	select {
-	case err, ok := <-cmder.errCh:
-		if ok {
-			return err.Error(), true // ummm...
-		}
-	case line, ok := <-cmder.lineCh:
-		return line, ok
+	case <-cmder.doneCh:
+		return true
+	case <-time.After(timeout):
 	}
 
-	return "", false
+	return false
+}
+
+// write writes str to stdin of the process
+func (cmder *Cmder) write(str string) error {
+	cmder.stdinMutex.Lock()
+	defer cmder.stdinMutex.Unlock()
+
+	_, err := io.WriteString(cmder.stdin, str)
+
+	return err
+}
+
+// Line returns a line written by the process
+// It returns false after the process exited and all lines are read
+func (cmder *Cmder) Line() (*Line, bool) {
+	line, ok := <-cmder.lineCh
+
+	return line, ok
 }
 
-func (cmder *Cmder) Send(str string) {
-	cmder.sendCh <- str
+// Send queues a line to be written to stdin of the process
+// It doesn't block, it returns an error if the queue is full
+func (cmder *Cmder) Send(str string) error {
+	if !strings.HasSuffix(str, "\n") {
+		str += "\n"
+	}
+
+	select {
+	case <-cmder.doneCh:
+		return errNotRunning
+	default:
+	}
+
+	select {
+	case cmder.sendCh <- str:
+	default:
+		return errSendFull
+	}
+
+	return nil
 }
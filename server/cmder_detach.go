@@ -0,0 +1,342 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/beito123/mimi/pks"
+)
+
+// A detached process is started in its own process group with files
+// in DetachDirName of the working directory instead of pipes
+// stdin is a named pipe, and stdout and stderr are written to files read by mimi,
+// so another mimi can adopt the process after mimi exited
+// The files are opened for appending and emptied after mimi read them all,
+// so they don't fill the disk while the process runs for a long time
+
+// DetachDirName is a directory having files of a detached process
+const DetachDirName = ".mimi"
+
+const (
+	// TailInterval is time to wait for new output of a detached process
+	TailInterval = 200 * time.Millisecond
+
+	// PollInterval is time to check an adopted process is alive
+	PollInterval = time.Second
+
+	// AdoptTailSize is size of output read again when a process is adopted
+	AdoptTailSize = 64 * 1024
+
+	// MaxOutputSize is size of an output file emptied after it's read
+	MaxOutputSize = 16 * 1024 * 1024
+)
+
+var (
+	errDetachUnsupported = errors.New("detached programs aren't supported on this platform")
+	errExitUnknown       = errors.New("an adopted process has exited with unknown status")
+)
+
+// DetachFiles returns paths of stdin, stdout and stderr of a detached process in dir
+func DetachFiles(dir string) (string, string, string) {
+	dir = filepath.Join(dir, DetachDirName)
+
+	return filepath.Join(dir, "stdin"), filepath.Join(dir, "stdout.log"), filepath.Join(dir, "stderr.log")
+}
+
+func (cmder *Cmder) startDetached(cmd *exec.Cmd) error {
+	inPath, outPath, errPath := DetachFiles(cmder.WorkingDir)
+
+	err := os.MkdirAll(filepath.Dir(inPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = makeFifo(inPath)
+	if err != nil {
+		return err
+	}
+
+	// the process opens the pipe for writing too, so it never reads EOF without mimi
+	stdin, err := os.OpenFile(inPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	defer stdin.Close()
+
+	stdout, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer stdout.Close()
+
+	stderr, err := os.OpenFile(errPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer stderr.Close()
+
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	setDetached(cmd)
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	cmder.process = cmd.Process
+
+	startTime := time.Now()
+
+	err = cmder.attach(&DetachOffsets{}, func() (*Exit, error) {
+		err := cmd.Wait()
+
+		return newExit(cmd.ProcessState, startTime), err
+	})
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+
+		return err
+	}
+
+	return nil
+}
+
+// Adopt attaches to a detached process started by another mimi
+// Output is read from offsets saved by the previous mimi. If they aren't saved,
+// the last AdoptTailSize bytes are read again when replay is true, or new output is read
+// Its exit status can't be known, it's treated as an error
+func (cmder *Cmder) Adopt(state *DetachState, replay bool) error {
+	if !cmder.Detached {
+		return errors.New("only detached processes can be adopted")
+	}
+
+	cmder.init()
+
+	process, err := os.FindProcess(state.PID)
+	if err != nil {
+		return err
+	}
+
+	cmder.process = process
+
+	offsets := state.Offsets
+	if offsets == nil {
+		_, outPath, errPath := DetachFiles(cmder.WorkingDir)
+
+		offsets = &DetachOffsets{
+			Stdout: adoptOffset(outPath, replay),
+			Stderr: adoptOffset(errPath, replay),
+		}
+	}
+
+	return cmder.attach(offsets, func() (*Exit, error) {
+		for state.Alive() {
+			time.Sleep(PollInterval)
+		}
+
+		return newExit(nil, state.StartTime), errExitUnknown
+	})
+}
+
+// adoptOffset returns an offset to start reading an output file of an adopted process
+// If replay is true, it's the first line in the last AdoptTailSize bytes, otherwise the end
+func adoptOffset(path string, replay bool) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+
+	if !replay {
+		return info.Size()
+	}
+
+	if info.Size() <= AdoptTailSize {
+		return 0
+	}
+
+	offset, err := f.Seek(-AdoptTailSize, io.SeekEnd)
+	if err != nil {
+		return 0
+	}
+
+	// skip a broken line
+	str, _ := bufio.NewReader(f).ReadString('\n')
+
+	return offset + int64(len(str))
+}
+
+// attach opens files of a detached process and starts reading them from offsets
+// wait must block until the process exits
+func (cmder *Cmder) attach(offsets *DetachOffsets, wait func() (*Exit, error)) error {
+	inPath, outPath, errPath := DetachFiles(cmder.WorkingDir)
+
+	stdout, err := os.Open(outPath)
+	if err != nil {
+		return err
+	}
+
+	stderr, err := os.Open(errPath)
+	if err != nil {
+		stdout.Close()
+
+		return err
+	}
+
+	stdin, err := openFifo(inPath)
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+
+		return err
+	}
+
+	cmder.stdin = stdin
+
+	stopCh := make(chan bool)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	cmder.offsets = *offsets
+
+	go cmder.tail(&wg, stdout, pks.StreamStdout, &cmder.offsets.Stdout, stopCh)
+	go cmder.tail(&wg, stderr, pks.StreamStderr, &cmder.offsets.Stderr, stopCh)
+
+	exitCh := make(chan *Exit, 1)
+
+	go func() {
+		exit, err := wait()
+
+		cmder.exitErr = err
+		exitCh <- exit
+	}()
+
+	go func() {
+		defer cmder.close()
+
+		select {
+		case exit := <-exitCh:
+			cmder.exit = exit
+		case <-cmder.releaseCh:
+			cmder.released = true
+		}
+
+		close(stopCh)
+		wg.Wait()
+
+		cmder.stdin.Close()
+	}()
+
+	go cmder.sender()
+
+	return nil
+}
+
+// tail reads lines written to f from *pos until stopCh is closed
+// pos is set to the offset read when it returns
+func (cmder *Cmder) tail(wg *sync.WaitGroup, f *os.File, stream byte, pos *int64, stopCh chan bool) {
+	defer wg.Done()
+	defer f.Close()
+
+	offset := *pos
+
+	// the file has been emptied after the offset was saved
+	info, err := f.Stat()
+	if err != nil || info.Size() < offset {
+		offset = 0
+	}
+
+	f.Seek(offset, io.SeekStart)
+
+	defer func() {
+		*pos = offset
+	}()
+
+	var lines lineBuffer
+	var stopped bool
+
+	buf := make([]byte, ReadBufferSize)
+	for {
+		n, err := f.Read(buf)
+		offset += int64(n)
+
+		for _, text := range lines.add(buf[:n]) {
+			cmder.send(stream, text)
+		}
+
+		if err == nil {
+			continue
+		}
+
+		if err != io.EOF {
+			logger.Errorf("Couldn't read %s: %s", f.Name(), err.Error())
+
+			break
+		}
+
+		if stopped {
+			break
+		}
+
+		if offset >= MaxOutputSize && lines.empty() && truncateOutput(f, offset) {
+			offset = 0
+		}
+
+		// read again after stopped, lines may be written just before exiting
+		select {
+		case <-stopCh:
+			stopped = true
+		case <-time.After(TailInterval):
+		}
+	}
+
+	if text, ok := lines.flush(); ok {
+		cmder.send(stream, text)
+	}
+}
+
+func truncateOutput(f *os.File, offset int64) bool {
+	info, err := f.Stat()
+	if err != nil || info.Size() != offset {
+		return false
+	}
+
+	err = os.Truncate(f.Name(), 0)
+	if err != nil {
+		logger.Errorf("Couldn't truncate %s: %s", f.Name(), err.Error())
+
+		return false
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+
+	return err == nil
+}
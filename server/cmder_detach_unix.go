@@ -0,0 +1,38 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func makeFifo(path string) error {
+	os.Remove(path)
+
+	return syscall.Mkfifo(path, 0600)
+}
+
+// openFifo opens a named pipe for writing
+// It fails instead of blocking if nobody reads the pipe
+func openFifo(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+}
+
+// setDetached makes cmd not receive signals sent to mimi
+func setDetached(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+}
@@ -0,0 +1,26 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"os"
+	"os/exec"
+)
+
+func makeFifo(path string) error {
+	return errDetachUnsupported
+}
+
+func openFifo(path string) (*os.File, error) {
+	return nil, errDetachUnsupported
+}
+
+func setDetached(cmd *exec.Cmd) {
+}
@@ -0,0 +1,40 @@
+//go:build linux
+// +build linux
+
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+// startPTY starts cmd under a pseudo-terminal and returns its master
+func startPTY(cmd *exec.Cmd, rows uint16, cols uint16) (*os.File, error) {
+	return pty.StartWithSize(cmd, &pty.Winsize{
+		Rows: rows,
+		Cols: cols,
+	})
+}
+
+// Resize changes the window size of the pseudo-terminal
+func (cmder *Cmder) Resize(rows uint16, cols uint16) error {
+	if cmder.tty == nil {
+		return errNotPTY
+	}
+
+	return pty.Setsize(cmder.tty, &pty.Winsize{
+		Rows: rows,
+		Cols: cols,
+	})
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+var errPTYUnsupported = errors.New("pseudo-terminals are only supported on linux")
+
+func startPTY(cmd *exec.Cmd, rows uint16, cols uint16) (*os.File, error) {
+	return nil, errPTYUnsupported
+}
+
+// Resize changes the window size of the pseudo-terminal
+func (cmder *Cmder) Resize(rows uint16, cols uint16) error {
+	return errPTYUnsupported
+}
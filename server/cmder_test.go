@@ -0,0 +1,53 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLineBuffer(t *testing.T) {
+	long := strings.Repeat("a", MaxLineLength)
+	wide := strings.Repeat("a", MaxLineLength-1) + "é" // a character across the limit
+
+	tests := []struct {
+		chunks []string
+		want   []string
+		rest   string
+	}{
+		{[]string{"one\ntwo\n"}, []string{"one", "two"}, ""},
+		{[]string{"o", "ne\r\n", "tw"}, []string{"one"}, "tw"},
+		{[]string{"\n\n"}, []string{"", ""}, ""},
+		{[]string{long + "b\n"}, []string{long, "b"}, ""},
+		{[]string{long, long, "c"}, []string{long, long}, "c"},
+		{[]string{long + "\n"}, []string{long}, ""},
+		{[]string{wide + "\n"}, []string{wide[:MaxLineLength-1], "é"}, ""},
+	}
+
+	for i, test := range tests {
+		var lb lineBuffer
+
+		var got []string
+		for _, chunk := range test.chunks {
+			got = append(got, lb.add([]byte(chunk))...)
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("test %d: add() returned %d lines, want %d", i, len(got), len(test.want))
+		}
+
+		rest, _ := lb.flush()
+		if rest != test.rest || !lb.empty() {
+			t.Errorf("test %d: flush() = %q, want %q", i, rest, test.rest)
+		}
+	}
+}
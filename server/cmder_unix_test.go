@@ -0,0 +1,67 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCmderLongLine(t *testing.T) {
+	cmder := &Cmder{}
+
+	err := cmder.Start("sh", "-c", "head -c 100000 /dev/zero | tr '\\0' a; echo; echo after")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var total int
+	var last string
+
+	timeout := time.After(5 * time.Second)
+	for {
+		lineCh := make(chan *Line)
+		go func() {
+			line, _ := cmder.Line()
+			lineCh <- line
+		}()
+
+		var line *Line
+		select {
+		case line = <-lineCh:
+		case <-timeout:
+			t.Fatal("the process didn't exit")
+		}
+
+		if line == nil {
+			break
+		}
+
+		if len(line.Text) > MaxLineLength {
+			t.Errorf("read a line of %d bytes", len(line.Text))
+		}
+
+		total += len(line.Text)
+		last = line.Text
+	}
+
+	if total != 100000+len("after") || last != "after" {
+		t.Errorf("read %d bytes ending with %q", total, last)
+	}
+
+	select {
+	case <-cmder.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("Done() wasn't closed")
+	}
+}
@@ -12,48 +12,148 @@ package server
 import (
 	"container/ring"
 	"context"
-
-	uuid "github.com/satori/go.uuid"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/beito123/mimi"
+	"github.com/beito123/mimi/color"
+	"github.com/beito123/mimi/pks"
 	"github.com/beito123/mimi/util"
+	uuid "github.com/satori/go.uuid"
 )
 
+const DefaultLogSize = 500
+
+// ExitLogLines is the number of lines kept in an exit record
+const ExitLogLines = 20
+
+// MaxLines is the number of lines sent in a packet at most
+const MaxLines = 255
+
+// MaxHistoryLines is the number of lines requested at once at most
+const MaxHistoryLines = 1000
+
+// DefaultHistoryLines is the number of lines sent for a request without count
+const DefaultHistoryLines = 100
+
+func NewConsoleManager() *ConsoleManager {
+	return &ConsoleManager{
+		Consoles: make(map[uuid.UUID]*Console),
+	}
+}
+
 type ConsoleManager struct {
 	Consoles map[uuid.UUID]*Console
+
+	// OnState is set to consoles created by the manager
+	OnState func(con *Console, state byte)
+
+	mutex sync.RWMutex
 }
 
 func (cm *ConsoleManager) Start(ctx context.Context) {
+	ticker := time.NewTicker(mimi.UpdateInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
-			for _, con := range cm.Consoles {
-				if !con.Closed() {
-					con.Close()
-				}
-			}
+			cm.closeConsoles()
 
-			break
-		default:
+			return
+		case <-ticker.C:
 		}
 
+		cm.mutex.Lock()
 		for _, con := range cm.Consoles {
 			if con.Closed() {
 				delete(cm.Consoles, con.UUID)
 			}
 		}
+		cm.mutex.Unlock()
+	}
+}
+
+func (cm *ConsoleManager) closeConsoles() {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, con := range cm.Consoles {
+		if con.Closed() {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(con *Console) {
+			defer wg.Done()
+
+			if con.Program.Detached {
+				con.Release()
+
+				return
+			}
+
+			con.Stop()
+		}(con)
 	}
+
+	wg.Wait()
 }
 
 func (cm *ConsoleManager) Add(con *Console) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
 	cm.Consoles[con.UUID] = con
 }
 
 func (cm *ConsoleManager) Get(uid uuid.UUID) (*Console, bool) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
 	con, ok := cm.Consoles[uid]
 	return con, ok
 }
 
-func (cm *ConsoleManager) NewConsole(loader Loader) (*Console, error) {
-	con, err := StartConsole(loader)
+// Range calls f for each console until f returns false
+func (cm *ConsoleManager) Range(f func(con *Console) bool) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	for _, con := range cm.Consoles {
+		if !f(con) {
+			break
+		}
+	}
+}
+
+// GetByProgram returns a running console of the program
+func (cm *ConsoleManager) GetByProgram(name string) (*Console, bool) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	for _, con := range cm.Consoles {
+		if con.Program.Name == name && !con.Closed() {
+			return con, true
+		}
+	}
+
+	return nil, false
+}
+
+func (cm *ConsoleManager) NewConsole(program *Program) (*Console, error) {
+	con, err := NewConsole(program)
+	if err != nil {
+		return nil, err
+	}
+
+	con.OnState = cm.OnState
+
+	err = con.Run()
 	if err != nil {
 		return nil, err
 	}
@@ -63,8 +163,14 @@ func (cm *ConsoleManager) NewConsole(loader Loader) (*Console, error) {
 	return con, nil
 }
 
-func StartConsole(loader Loader) (*Console, error) {
-	con := &Console{}
+// NewConsole returns a console of program, call Run to start it
+func NewConsole(program *Program) (*Console, error) {
+	con := &Console{
+		Program: program,
+		Logs:    NewLogStacker(DefaultLogSize),
+		state:   pks.ConsoleStarting,
+		created: time.Now(),
+	}
 
 	var err error
 
@@ -73,150 +179,786 @@ func StartConsole(loader Loader) (*Console, error) {
 		return nil, err
 	}
 
-	con.Cmder = &Cmder{
-		WorkingDir: loader.Path(),
+	if len(program.LogDir) > 0 {
+		con.LogFile, err = NewLogFile(program.LogDir, program.LogMaxSize, program.LogMaxAge)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	program, args := loader.Cmd()
+	return con, nil
+}
 
-	con.Cmder.Start(program, args...)
+func StartConsole(program *Program) (*Console, error) {
+	con, err := NewConsole(program)
+	if err != nil {
+		return nil, err
+	}
 
-	go con.start()
+	err = con.Run()
+	if err != nil {
+		return nil, err
+	}
 
 	return con, nil
 }
 
 type Console struct { // sync for each session
-	UUID  uuid.UUID
-	Cmder *Cmder
+	UUID    uuid.UUID
+	Program *Program
+	Cmder   *Cmder
+
+	Logs    *LogStacker
+	LogFile *LogFile // nil if disabled
+
+	// OnState is called when the state is changed
+	OnState func(con *Console, state byte)
+
+	state      byte // pks.ConsoleStarting etc.
+	restarting bool // the program will be started again
+	retries    int
+	retryCh    chan bool // closed to cancel a waiting restart
+	startTime  time.Time
+	created    time.Time // lines in log files are read from this date
+	runDone    chan bool // closed after the running program exited and was recorded
+	exit       *Exit
+	mutex      sync.Mutex
+
+	opMutex sync.Mutex // serializes Stop, Restart, Close and automatic restarts
+
+	subscribers []*Subscriber
+	subMutex    sync.RWMutex
+}
 
-	Logs *LogStacker
+// State returns a state of the console
+func (con *Console) State() byte {
+	con.mutex.Lock()
+	defer con.mutex.Unlock()
 
-	closeCh chan bool
-	closed  bool
+	return con.state
 }
 
+// Restarting returns whether the program will be started again
+func (con *Console) Restarting() bool {
+	con.mutex.Lock()
+	defer con.mutex.Unlock()
+
+	return con.restarting
+}
+
+// LastExit returns the last exit of the program, or nil
+func (con *Console) LastExit() *Exit {
+	con.mutex.Lock()
+	defer con.mutex.Unlock()
+
+	return con.exit
+}
+
+// Closed returns whether the program has exited and won't be restarted
 func (con *Console) Closed() bool {
-	return con.closed
+	con.mutex.Lock()
+	defer con.mutex.Unlock()
+
+	return con.closed()
 }
 
-func (con *Console) start() {
+func (con *Console) closed() bool {
+	return (con.state == pks.ConsoleStopped || con.state == pks.ConsoleCrashed) && !con.restarting
+}
+
+func (con *Console) setState(state byte) {
+	con.mutex.Lock()
+	changed := con.state != state
+	con.state = state
+	con.mutex.Unlock()
+
+	if changed {
+		con.notify(state)
+	}
+}
+
+// transit changes the state from one to another if cmder is still used
+func (con *Console) transit(cmder *Cmder, from byte, to byte) {
+	con.mutex.Lock()
+	ok := con.Cmder == cmder && con.state == from
+	if ok {
+		con.state = to
+	}
+	con.mutex.Unlock()
+
+	if ok {
+		con.notify(to)
+	}
+}
+
+func (con *Console) notify(state byte) {
+	con.publishState(state)
+
+	if con.OnState != nil {
+		con.OnState(con, state)
+	}
+
+	if con.Closed() {
+		con.Program.detach(con)
+		con.closeLog()
+	}
+}
+
+func (con *Console) closeLog() {
+	if con.LogFile == nil {
+		return
+	}
+
+	err := con.LogFile.Close()
+	if err != nil {
+		logger.Errorf("Couldn't close a log file of %s: %s", con.Program.Name, err.Error())
+	}
+}
+
+// readyPattern returns a pattern of the loader, or nil
+func (con *Console) readyPattern() *regexp.Regexp {
+	loader, ok := con.Program.Loader.(ReadyLoader)
+	if !ok {
+		return nil
+	}
+
+	return loader.ReadyPattern()
+}
+
+// Run starts the program of a new console
+// It fails if the program is running on another console
+func (con *Console) Run() error {
+	err := con.Program.attach(con, false)
+	if err != nil {
+		return err
+	}
+
+	err = con.run()
+	if err != nil {
+		con.setState(pks.ConsoleCrashed)
+
+		return err
+	}
+
+	return nil
+}
+
+// run starts the program of the console
+func (con *Console) run() error {
+	cmder := con.newCmder()
+
+	program, args := con.Program.Loader.Cmd()
+
+	err := cmder.Start(program, args...)
+	if err != nil {
+		return err
+	}
+
+	if con.Program.Detached {
+		err = SaveDetachState(con.Program.Loader.Path(), &DetachState{
+			PID:         cmder.Pid(),
+			ConsoleUUID: con.UUID,
+			StartTime:   time.Now(),
+		})
+		if err != nil {
+			logger.Errorf("Couldn't save a state of %s: %s", con.Program.Name, err.Error())
+		}
+	}
+
+	// programs without a pattern are ready as soon as they're started
+	state := pks.ConsoleReady
+	if con.readyPattern() != nil {
+		state = pks.ConsoleStarting
+	}
+
+	con.use(cmder, state)
+
+	return nil
+}
+
+// adopt attaches to a detached program started by another mimi
+func (con *Console) adopt(state *DetachState) error {
+	err := con.Program.attach(con, true)
+	if err != nil {
+		return err
+	}
+
+	con.created = state.StartTime
+
+	// continue numbering lines saved by the previous mimi
+	// output saved in the log file isn't read again
+	replay := true
+	if con.LogFile != nil {
+		lines, err := con.LogFile.History(con.logTag(), 0, 1, con.created)
+		if err != nil {
+			logger.Errorf("Couldn't read a log file of %s: %s", con.Program.Name, err.Error())
+		} else if len(lines) > 0 {
+			con.Logs.SetSeq(lines[0].Seq)
+
+			replay = false
+		}
+	}
+
+	cmder := con.newCmder()
+
+	err = cmder.Adopt(state, replay)
+	if err != nil {
+		con.setState(pks.ConsoleCrashed)
+
+		return err
+	}
+
+	// the offsets are old after new output is read
+	if state.Offsets != nil {
+		state.Offsets = nil
+
+		err = SaveDetachState(con.Program.Loader.Path(), state)
+		if err != nil {
+			logger.Errorf("Couldn't save a state of %s: %s", con.Program.Name, err.Error())
+		}
+	}
+
+	con.use(cmder, pks.ConsoleReady)
+
+	return nil
+}
+
+func (con *Console) newCmder() *Cmder {
+	cmder := &Cmder{
+		WorkingDir: con.Program.Loader.Path(),
+		PTY:        con.Program.PTY,
+		Detached:   con.Program.Detached,
+	}
+
+	if loader, ok := con.Program.Loader.(EnvLoader); ok {
+		cmder.Env = loader.Env()
+	}
+
+	return cmder
+}
+
+// use sets a started cmder to the console
+func (con *Console) use(cmder *Cmder, state byte) {
+	done := make(chan bool)
+
+	con.mutex.Lock()
+	con.Cmder = cmder
+	con.state = state
+	con.restarting = false
+	con.startTime = time.Now()
+	con.runDone = done
+	con.mutex.Unlock()
+
+	con.notify(state)
+
+	go func() {
+		defer close(done)
+
+		con.start(cmder)
+	}()
+}
+
+func (con *Console) start(cmder *Cmder) {
+	ready := con.readyPattern()
+
 	for {
-		line, ok := con.Cmder.Line()
+		line, ok := cmder.Line()
 		if !ok {
 			break
 		}
 
+		line.Time = time.Now()
+
 		con.Logs.Add(line)
+		con.publish(line)
+
+		if con.LogFile != nil {
+			err := con.LogFile.Write(con.logTag(), line)
+			if err != nil && err != errLogClosed {
+				logger.Errorf("Couldn't write a log of %s: %s", con.Program.Name, err.Error())
+			}
+		}
+
+		if ready != nil && ready.MatchString(color.Strip(line.Text)) {
+			ready = nil
+
+			con.transit(cmder, pks.ConsoleStarting, pks.ConsoleReady)
+		}
 	}
+
+	con.exited(cmder)
 }
 
-func (con *Console) Close() {
-	if con.closed {
+// exited changes the state after cmder exited
+// The program has crashed if it exited with an error without being stopped,
+// and it's restarted later according to the restart policy
+func (con *Console) exited(cmder *Cmder) {
+	if cmder.Released() { // still running
 		return
 	}
 
-	con.closed = true
+	if con.Program.Detached {
+		err := RemoveDetachState(con.Program.Loader.Path())
+		if err != nil && !os.IsNotExist(err) {
+			logger.Errorf("Couldn't remove a state of %s: %s", con.Program.Name, err.Error())
+		}
+	}
+
+	exit := cmder.Exit()
+	exit.Lines = con.Logs.Last(ExitLogLines)
+
+	if len(exit.Signal) > 0 {
+		logger.Infof("%s was killed by %s after %s", con.Program.Name, exit.Signal, exit.Duration.String())
+	} else if cmder.Err() == errExitUnknown {
+		logger.Infof("%s has exited after %s", con.Program.Name, exit.Duration.String())
+	} else {
+		logger.Infof("%s exited with code %d after %s", con.Program.Name, exit.Code, exit.Duration.String())
+	}
+
+	con.Program.SetLastExit(con.UUID, exit)
+
+	con.mutex.Lock()
+	if con.Cmder != cmder {
+		con.mutex.Unlock()
+		return
+	}
+
+	con.exit = exit
+
+	if con.restarting {
+		con.mutex.Unlock()
+		return
+	}
+
+	requested := con.state == pks.ConsoleStopping || con.state == pks.ConsoleStopped
+
+	state := pks.ConsoleStopped
+	if cmder.Err() != nil && !requested {
+		state = pks.ConsoleCrashed
+	}
+
+	if time.Since(con.startTime) >= RestartResetTime {
+		con.retries = 0
+	}
+
+	var retryCh chan bool
+	var delay time.Duration
+	if !requested && con.Program.Restart.Should(cmder.Err()) {
+		retryCh, delay = con.scheduleRetry()
+	}
 
-	con.Cmder.Close()
+	changed := con.state != state
+	con.state = state
+	con.mutex.Unlock()
+
+	if changed {
+		con.notify(state)
+	}
+
+	if retryCh != nil {
+		go con.retry(retryCh, delay)
+	}
 }
 
-func (con *Console) Lines(t *LogTracker) []string {
-	return con.Logs.AllChanges(t)
+// scheduleRetry prepares an automatic restart and returns its channel and delay
+// It returns nil if the program has been restarted too many times
+// The caller must hold mutex
+func (con *Console) scheduleRetry() (chan bool, time.Duration) {
+	if con.Program.MaxRetries >= 0 && con.retries >= con.Program.MaxRetries {
+		logger.Warnf("Gave up restarting %s after %d retries", con.Program.Name, con.retries)
+
+		return nil, 0
+	}
+
+	delay := con.Program.Backoff(con.retries)
+
+	con.retries++
+	con.restarting = true
+	con.retryCh = make(chan bool)
+
+	logger.Infof("Restarting %s in %s (retry %d)", con.Program.Name, delay.String(), con.retries)
+
+	return con.retryCh, delay
 }
 
-func (con *Console) SendCommand(cmd string) {
-	con.Cmder.Send(cmd)
+// retry starts the program again after delay unless it's canceled
+func (con *Console) retry(cancel chan bool, delay time.Duration) {
+	select {
+	case <-cancel:
+		return
+	case <-time.After(delay):
+	}
+
+	con.opMutex.Lock()
+	defer con.opMutex.Unlock()
+
+	select {
+	case <-cancel:
+		return
+	default:
+	}
+
+	con.mutex.Lock()
+	con.retryCh = nil
+	con.mutex.Unlock()
+
+	err := con.run()
+	if err == nil {
+		return
+	}
+
+	logger.Errorf("Couldn't restart %s: %s", con.Program.Name, err.Error())
+
+	con.mutex.Lock()
+	retryCh, delay := con.scheduleRetry()
+	if retryCh == nil {
+		con.restarting = false
+	}
+
+	changed := con.state != pks.ConsoleCrashed
+	con.state = pks.ConsoleCrashed
+	con.mutex.Unlock()
+
+	if changed || retryCh == nil {
+		con.notify(pks.ConsoleCrashed)
+	}
+
+	if retryCh != nil {
+		go con.retry(retryCh, delay)
+	}
 }
 
-func NewLogTracker() *LogTracker {
-	return &LogTracker{
-		ChangeCounter: -1,
+// cancelRetry cancels a waiting automatic restart
+// It returns false if there is no waiting restart
+func (con *Console) cancelRetry() bool {
+	con.mutex.Lock()
+	defer con.mutex.Unlock()
+
+	if con.retryCh == nil {
+		return false
 	}
+
+	close(con.retryCh)
+	con.retryCh = nil
+	con.restarting = false
+
+	return true
 }
 
-type LogTracker struct {
-	id            int
-	ChangeCounter int
+func (con *Console) cmder() *Cmder {
+	con.mutex.Lock()
+	defer con.mutex.Unlock()
+
+	return con.Cmder
 }
 
-func NewLogStacker(n int) *LogStacker {
-	return &LogStacker{
-		logs: ring.New(n),
+// stop stops the program and waits until the exit is recorded
+func (con *Console) stop() error {
+	con.mutex.Lock()
+	cmder := con.Cmder
+	done := con.runDone
+	con.mutex.Unlock()
+
+	err := cmder.Stop(con.Program.Loader.StopCommand(), con.Program.StopTimeout)
+
+	<-done
+
+	return err
+}
+
+// Stop stops the program gracefully and waits for it to exit
+func (con *Console) Stop() error {
+	con.opMutex.Lock()
+	defer con.opMutex.Unlock()
+
+	if con.cancelRetry() {
+		con.setState(pks.ConsoleStopped)
+
+		return nil
+	}
+
+	if con.Closed() {
+		return errNotRunning
 	}
+
+	con.setState(pks.ConsoleStopping)
+
+	err := con.stop()
+
+	con.setState(pks.ConsoleStopped)
+
+	return err
 }
 
-type LogStacker struct {
-	logs    *ring.Ring
-	counter int
+// Restart stops the program and starts it again with the same console
+func (con *Console) Restart() error {
+	con.opMutex.Lock()
+	defer con.opMutex.Unlock()
+
+	con.cancelRetry()
+
+	con.mutex.Lock()
+	con.restarting = true
+	con.retries = 0
+	con.mutex.Unlock()
+
+	con.setState(pks.ConsoleStopping)
+
+	err := con.stop()
+	if err == nil || err == errNotRunning {
+		err = con.run()
+	}
+
+	if err != nil {
+		con.mutex.Lock()
+		con.restarting = false
+		con.mutex.Unlock()
+
+		con.setState(pks.ConsoleCrashed)
+
+		return err
+	}
 
-	trackerID int
-	trackers  []*LogTracker
+	return nil
 }
 
-func (st *LogStacker) Add(str string) {
-	st.add(str)
+// Release leaves a detached program running and stops reading it
+func (con *Console) Release() {
+	if !con.Program.Detached {
+		return
+	}
+
+	con.mutex.Lock()
+	cmder := con.Cmder
+	done := con.runDone
+	con.mutex.Unlock()
+
+	if cmder != nil {
+		cmder.Release()
 
-	st.counter++
+		// lines read before released are written to the log file
+		<-done
 
-	for _, t := range st.trackers {
-		t.ChangeCounter++
+		if cmder.Released() {
+			con.saveOffsets(cmder.Offsets())
+		}
 	}
+
+	con.closeLog()
 }
 
-func (st *LogStacker) Get(n int) []string {
-	return st.prev(n)
+// saveOffsets saves offsets of output read, the next mimi reads output after them
+func (con *Console) saveOffsets(offsets *DetachOffsets) {
+	dir := con.Program.Loader.Path()
+
+	state, err := LoadDetachState(dir)
+	if err == nil {
+		state.Offsets = offsets
+
+		err = SaveDetachState(dir, state)
+	}
+
+	if err != nil {
+		logger.Errorf("Couldn't save a state of %s: %s", con.Program.Name, err.Error())
+	}
 }
 
-func (st *LogStacker) Changes(n int, t *LogTracker) []string {
-	t.ChangeCounter = 0
+// Close kills the program
+func (con *Console) Close() {
+	con.opMutex.Lock()
+	defer con.opMutex.Unlock()
+
+	canceled := con.cancelRetry()
 
-	if t.ChangeCounter == -1 {
-		return st.Get(util.MinInt(n, util.MinInt(st.counter, st.logs.Len())))
+	con.mutex.Lock()
+	if con.closed() && !canceled {
+		con.mutex.Unlock()
+		return
 	}
 
-	return st.Get(util.MinInt(n, util.MinInt(t.ChangeCounter, st.logs.Len())))
+	con.state = pks.ConsoleStopped
+	cmder := con.Cmder
+	con.mutex.Unlock()
+
+	con.notify(pks.ConsoleStopped)
+
+	cmder.Close()
 }
 
-func (st *LogStacker) AllChanges(t *LogTracker) []string {
-	t.ChangeCounter = 0
+// History returns n lines at most before seq, from old to new
+// Lines no longer kept are read from log files if they're enabled
+// If seq is 0, it returns the last lines
+func (con *Console) History(seq int64, n int) ([]*Line, error) {
+	if seq <= 0 || seq > con.Logs.Seq() {
+		seq = con.Logs.Seq() + 1
+	}
 
-	return st.Get(util.MinInt(st.counter, st.logs.Len()))
+	lines := con.Logs.Before(seq, n)
+	if len(lines) >= n || con.LogFile == nil {
+		return lines, nil
+	}
+
+	if len(lines) > 0 {
+		seq = lines[0].Seq
+	}
+
+	if seq <= 1 {
+		return lines, nil
+	}
+
+	older, err := con.LogFile.History(con.logTag(), seq, n-len(lines), con.created)
+
+	return append(older, lines...), err
 }
 
-func (st *LogStacker) AddTracker(t *LogTracker) {
-	t.id = st.trackerID
-	st.trackerID++
+// Search returns the last n lines at most matching filter, from old to new
+// Lines no longer kept are searched in log files if they're enabled
+func (con *Console) Search(filter *LineFilter, n int) ([]*Line, error) {
+	lines, first := con.Logs.Find(n, filter.Match)
+	if len(lines) >= n || con.LogFile == nil || first <= 1 {
+		return lines, nil
+	}
+
+	since := con.created
+	if filter.Since.After(since) {
+		since = filter.Since
+	}
+
+	older, err := con.LogFile.Find(con.logTag(), n-len(lines), since, func(line *Line) bool {
+		return line.Seq < first && filter.Match(line)
+	})
 
-	st.trackers = append(st.trackers, t)
+	return append(older, lines...), err
 }
 
-func (st *LogStacker) RemoveTracker(t *LogTracker) {
-	nt := make([]*LogTracker, len(st.trackers))
-	for _, v := range st.trackers {
-		if v.id != t.id {
-			nt = append(nt, v)
-		}
+// logTag returns a tag of lines written to log files
+func (con *Console) logTag() string {
+	return con.UUID.String()[:8]
+}
+
+func (con *Console) SendCommand(cmd string) error {
+	return con.cmder().Send(cmd)
+}
+
+// Resize changes the window size if the console runs on a pseudo-terminal
+func (con *Console) Resize(rows uint16, cols uint16) error {
+	return con.cmder().Resize(rows, cols)
+}
+
+func NewLogStacker(n int) *LogStacker {
+	return &LogStacker{
+		logs: ring.New(n),
 	}
 }
 
-func (st *LogStacker) add(str string) {
-	st.logs.Value = str
+// LogStacker keeps the last lines of a console and numbers them
+type LogStacker struct {
+	logs  *ring.Ring // the next position to add
+	len   int
+	seq   int64 // sequence number of the last line
+	mutex sync.RWMutex
+}
+
+// Add numbers a line and adds it
+func (st *LogStacker) Add(line *Line) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.seq++
+	line.Seq = st.seq
+
+	st.logs.Value = line
 	st.logs = st.logs.Next()
+
+	if st.len < st.logs.Len() {
+		st.len++
+	}
+}
+
+// Seq returns a sequence number of the last line, 0 if nothing is added
+func (st *LogStacker) Seq() int64 {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	return st.seq
+}
+
+// SetSeq continues numbering after seq, it must be called before lines are added
+func (st *LogStacker) SetSeq(seq int64) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.seq = seq
+}
+
+// Last returns the last n lines at most, from old to new
+func (st *LogStacker) Last(n int) []*Line {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	return st.before(st.seq+1, n)
+}
+
+// Before returns n lines at most before seq, from old to new
+func (st *LogStacker) Before(seq int64, n int) []*Line {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	return st.before(seq, n)
 }
 
-func (st *LogStacker) prev(n int) []string {
-	logs := st.logs // it won't change for master
+// Find returns the last n lines at most matching match from old to new,
+// and a sequence number of the oldest line kept
+func (st *LogStacker) Find(n int, match func(line *Line) bool) ([]*Line, int64) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	var lines []*Line
+
+	logs := st.logs
+	for i := 0; i < st.len && len(lines) < n; i++ {
+		logs = logs.Prev()
+
+		line, ok := logs.Value.(*Line)
+		if !ok {
+			panic("couldn't convert to *Line")
+		}
+
+		if match(line) {
+			lines = append(lines, line)
+		}
+	}
+
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	return lines, st.seq - int64(st.len) + 1
+}
+
+func (st *LogStacker) before(seq int64, n int) []*Line {
+	if seq > st.seq+1 {
+		seq = st.seq + 1
+	}
+
+	first := st.seq - int64(st.len) + 1 // the oldest kept
+
+	count := util.MinInt(int(seq-first), n)
+	if count <= 0 {
+		return nil
+	}
+
+	logs := st.logs.Move(-int(st.seq + 1 - seq))
 
 	var ok bool
-	data := make([]string, n)
-	for i := 0; i < n; i++ {
+	lines := make([]*Line, count)
+	for i := count - 1; i >= 0; i-- {
 		logs = logs.Prev()
-		data[i], ok = logs.Value.(string)
+		lines[i], ok = logs.Value.(*Line)
 		if !ok {
-			panic("couldn't convert to string")
+			panic("couldn't convert to *Line")
 		}
 	}
 
-	return data
+	return lines
 }
@@ -0,0 +1,111 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestStacker returns a stacker of size having lines numbered from 1 to n
+func newTestStacker(size int, n int) *LogStacker {
+	st := NewLogStacker(size)
+	for i := 1; i <= n; i++ {
+		st.Add(&Line{
+			Text: "line " + strconv.Itoa(i),
+		})
+	}
+
+	return st
+}
+
+func seqs(lines []*Line) []int64 {
+	var s []int64
+	for _, line := range lines {
+		s = append(s, line.Seq)
+	}
+
+	return s
+}
+
+func TestLogStackerBefore(t *testing.T) {
+	tests := []struct {
+		size, added int
+		seq         int64
+		n           int
+		want        []int64
+	}{
+		{5, 0, 0, 3, nil},
+		{5, 3, 4, 10, []int64{1, 2, 3}},
+		{5, 3, 3, 10, []int64{1, 2}},
+		{5, 8, 9, 3, []int64{6, 7, 8}},
+		{5, 8, 9, 10, []int64{4, 5, 6, 7, 8}},
+		{5, 8, 6, 10, []int64{4, 5}},
+		{5, 8, 4, 10, nil}, // older lines aren't kept
+		{5, 8, 100, 2, []int64{7, 8}},
+		{5, 8, 9, 0, nil},
+	}
+
+	for _, test := range tests {
+		st := newTestStacker(test.size, test.added)
+
+		got := seqs(st.Before(test.seq, test.n))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Before(%d, %d) of %d/%d lines = %v, want %v", test.seq, test.n, test.added, test.size, got, test.want)
+		}
+	}
+}
+
+func TestLogStackerSetSeq(t *testing.T) {
+	st := NewLogStacker(5)
+	st.SetSeq(41)
+	st.Add(&Line{})
+
+	if st.Seq() != 42 {
+		t.Errorf("Seq() = %d, want 42", st.Seq())
+	}
+
+	got := seqs(st.Last(10))
+	if !reflect.DeepEqual(got, []int64{42}) {
+		t.Errorf("Last(10) = %v, want [42]", got)
+	}
+}
+
+func TestLogStackerFind(t *testing.T) {
+	st := newTestStacker(5, 12) // keeps 8 to 12
+
+	even := func(line *Line) bool {
+		return line.Seq%2 == 0
+	}
+
+	tests := []struct {
+		n     int
+		match func(line *Line) bool
+		want  []int64
+	}{
+		{10, even, []int64{8, 10, 12}},
+		{2, even, []int64{10, 12}},
+		{10, func(line *Line) bool { return strings.HasSuffix(line.Text, "11") }, []int64{11}},
+		{10, func(line *Line) bool { return false }, nil},
+	}
+
+	for _, test := range tests {
+		lines, first := st.Find(test.n, test.match)
+		if got := seqs(lines); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Find(%d) = %v, want %v", test.n, got, test.want)
+		}
+
+		if first != 8 {
+			t.Errorf("Find(%d) returned the oldest seq %d, want 8", test.n, first)
+		}
+	}
+}
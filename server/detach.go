@@ -0,0 +1,147 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/beito123/mimi/util"
+	uuid "github.com/satori/go.uuid"
+)
+
+// DetachStateFileName is a file saving a state of a detached program in DetachDirName
+const DetachStateFileName = "state.json"
+
+// StartTimeTolerance is allowed difference between a saved start time and a start time of a process
+const StartTimeTolerance = 3 * time.Second
+
+// DetachState is a state of a detached program saved for the next mimi
+type DetachState struct {
+	PID         int            `json:"pid"`
+	ConsoleUUID uuid.UUID      `json:"console_uuid"`
+	StartTime   time.Time      `json:"start_time"`
+	Offsets     *DetachOffsets `json:"offsets,omitempty"` // saved when mimi released the program
+}
+
+// DetachOffsets are offsets of output files read by mimi
+type DetachOffsets struct {
+	Stdout int64 `json:"stdout"`
+	Stderr int64 `json:"stderr"`
+}
+
+// Alive returns whether the process of the state is still running
+// A process reusing the pid after a reboot is started at another time, so it isn't treated as the program
+func (state *DetachState) Alive() bool {
+	if !util.ProcessExists(state.PID) {
+		return false
+	}
+
+	start, err := util.ProcessStartTime(state.PID)
+	if err != nil {
+		// the process has exited just now, or its start time can't be read
+		logger.Debugf("Couldn't get a start time of pid %d: %s", state.PID, err.Error())
+
+		return util.ProcessExists(state.PID)
+	}
+
+	diff := start.Sub(state.StartTime)
+
+	return diff > -StartTimeTolerance && diff < StartTimeTolerance
+}
+
+func detachStateFile(dir string) string {
+	return filepath.Join(dir, DetachDirName, DetachStateFileName)
+}
+
+// LoadDetachState reads a state of a detached program in dir
+func LoadDetachState(dir string) (*DetachState, error) {
+	b, err := ioutil.ReadFile(detachStateFile(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	state := &DetachState{}
+
+	err = json.Unmarshal(b, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// SaveDetachState writes a state of a detached program in dir
+func SaveDetachState(dir string, state *DetachState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(detachStateFile(dir), b, 0644)
+}
+
+// RemoveDetachState removes a state of a detached program in dir
+func RemoveDetachState(dir string) error {
+	return os.Remove(detachStateFile(dir))
+}
+
+// Adopt restores consoles of detached programs left running by a previous mimi
+// The consoles have the same UUIDs as before
+func (cm *ConsoleManager) Adopt(pm *ProgramManager) {
+	for _, program := range pm.Programs {
+		if !program.Detached {
+			continue
+		}
+
+		dir := program.Loader.Path()
+
+		state, err := LoadDetachState(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			logger.Errorf("Couldn't load a state of %s: %s", program.Name, err.Error())
+
+			continue
+		}
+
+		if !state.Alive() {
+			logger.Infof("%s has exited while mimi was stopped", program.Name)
+
+			RemoveDetachState(dir)
+
+			continue
+		}
+
+		con, err := NewConsole(program)
+		if err != nil {
+			logger.Errorf("Couldn't create a console of %s: %s", program.Name, err.Error())
+
+			continue
+		}
+
+		con.UUID = state.ConsoleUUID
+		con.OnState = cm.OnState
+
+		err = con.adopt(state)
+		if err != nil {
+			logger.Errorf("Couldn't adopt %s (pid: %d): %s", program.Name, state.PID, err.Error())
+
+			continue
+		}
+
+		cm.Add(con)
+
+		logger.Infof("Adopted %s (pid: %d, console: %s)", program.Name, state.PID, con.UUID.String())
+	}
+}
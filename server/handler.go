@@ -27,19 +27,21 @@ var upgrader = websocket.Upgrader{
 }
 
 type AuthHandler struct {
-	Token   string
+	Users   *UserManager
 	Limiter *Limiter
 }
 
-func (hand *AuthHandler) Auth(rw mimi.Render, req *http.Request) (bool, error) {
+// Auth returns an authenticated user, or nil if the request is rejected
+// Users are authenticated by basic auth, or the token by the token query
+func (hand *AuthHandler) Auth(rw mimi.Render, req *http.Request) (*User, error) {
 	ip, err := util.IP(req.RemoteAddr)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	ok, err := hand.Limiter.Check(ip)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	if !ok {
@@ -47,23 +49,30 @@ func (hand *AuthHandler) Auth(rw mimi.Render, req *http.Request) (bool, error) {
 			Status: http.StatusForbidden,
 			Error:  "You are blocked",
 		})
+
+		return nil, nil
 	}
 
-	token := req.URL.Query().Get("token")
-	if len(token) < 0 {
-		return false, nil
+	var user *User
+
+	// passwords aren't accepted in the query, urls are written to logs
+	name, password, basic := req.BasicAuth()
+	if basic {
+		user, ok = hand.Users.Auth(name, password)
+	} else {
+		user, ok = hand.Users.AuthToken(req.URL.Query().Get(mimi.QueryToken))
 	}
 
-	if hand.Token != token {
+	if !ok {
 		rw.Write(&mimi.Base{
 			Status: http.StatusUnauthorized,
 			Error:  "Unauthorized",
 		})
 
-		return false, nil
+		return nil, nil
 	}
 
-	return true, nil
+	return user, nil
 }
 
 type StreamHandler struct {
@@ -74,24 +83,26 @@ type StreamHandler struct {
 func (hand *StreamHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	render := mimi.NewJSONRender(rw)
 
-	ok, err := hand.Auth.Auth(render, req)
+	user, err := hand.Auth.Auth(render, req)
 	if err != nil {
-		mimi.Dump(err)
+		mimi.Error("couldn't authenticate a request error: %s", err.Error())
 		return
 	}
 
-	if !ok {
+	if user == nil {
 		return
 	}
 
 	conn, err := upgrader.Upgrade(rw, req, nil)
 	if err != nil {
-		mimi.Dump(err)
+		mimi.Error("couldn't upgrade a connection error: %s", err.Error())
 		return
 	}
 
-	err = hand.Manager.NewSession(conn)
+	err = hand.Manager.NewSession(conn, user)
 	if err != nil {
-		mimi.Dump(err)
+		mimi.Error("couldn't create a session error: %s", err.Error())
+
+		conn.Close()
 	}
 }
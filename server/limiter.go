@@ -11,6 +11,7 @@ package server
 
 import (
 	"net"
+	"sync"
 	"time"
 )
 
@@ -31,6 +32,7 @@ type Limiter struct {
 	MaxCount int
 
 	lastUpdateTime time.Time
+	mutex          sync.Mutex
 }
 
 func (hand *Limiter) Update() {
@@ -92,6 +94,9 @@ func (hand *Limiter) SetBlock(ip net.IP, b bool) {
 }
 
 func (hand *Limiter) Check(ip net.IP) (bool, error) {
+	hand.mutex.Lock()
+	defer hand.mutex.Unlock()
+
 	hand.Update()
 
 	if !hand.HasAddr(ip) {
@@ -12,6 +12,7 @@ package server
 import (
 	"errors"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/beito123/mimi/util"
@@ -19,6 +20,20 @@ import (
 
 type LoaderManager struct {
 	Loaders map[string]Loader
+
+	names []string // in order of registration, used for detection
+}
+
+func NewLoaderManager(loaders ...Loader) *LoaderManager {
+	lm := &LoaderManager{
+		Loaders: make(map[string]Loader),
+	}
+
+	for _, loader := range loaders {
+		lm.Add(loader)
+	}
+
+	return lm
 }
 
 func (lm *LoaderManager) Get(name string) (Loader, bool) {
@@ -30,12 +45,56 @@ func (lm *LoaderManager) Get(name string) (Loader, bool) {
 	return loader.New(), true
 }
 
+// Find returns a loader by name ignoring case
+func (lm *LoaderManager) Find(name string) (Loader, bool) {
+	loader, ok := lm.Get(name)
+	if ok {
+		return loader, true
+	}
+
+	for n := range lm.Loaders {
+		if strings.EqualFold(n, name) {
+			return lm.Get(n)
+		}
+	}
+
+	return nil, false
+}
+
+// Detect inspects path and returns a matching loader and the reason
+// Loaders are tried in order of registration
+func (lm *LoaderManager) Detect(path string) (Loader, string, bool) {
+	for _, name := range lm.names {
+		detector, ok := lm.Loaders[name].(DetectLoader)
+		if !ok {
+			continue
+		}
+
+		if reason, ok := detector.Detect(path); ok {
+			return detector.New(), reason, true
+		}
+	}
+
+	return nil, "", false
+}
+
 func (lm *LoaderManager) Add(loader Loader) {
+	if _, ok := lm.Loaders[loader.Name()]; !ok {
+		lm.names = append(lm.names, loader.Name())
+	}
+
 	lm.Loaders[loader.Name()] = loader
 }
 
 func (lm *LoaderManager) Remove(name string) {
 	delete(lm.Loaders, name)
+
+	for i, n := range lm.names {
+		if n == name {
+			lm.names = append(lm.names[:i], lm.names[i+1:]...)
+			break
+		}
+	}
 }
 
 type Loader interface {
@@ -43,11 +102,34 @@ type Loader interface {
 	Path() string
 	Cmd() (string, []string)
 
+	// StopCommand returns a command stopping the program gracefully
+	// If it's empty, the program is stopped by signals
+	StopCommand() string
+
 	Init(path string, options map[string]string) error
 
 	New() Loader
 }
 
+// ReadyLoader is a Loader knowing a line printed when its program is ready
+type ReadyLoader interface {
+	Loader
+
+	// ReadyPattern returns a pattern matching the line, or nil
+	ReadyPattern() *regexp.Regexp
+}
+
+// DoneReadyPattern matches "Done (1.234s)!" printed by PMMP, Nukkit and Java Edition servers
+var DoneReadyPattern = regexp.MustCompile(`Done \([0-9.,]+s\)!`)
+
+// DetectLoader is a Loader able to recognize its program in a directory
+type DetectLoader interface {
+	Loader
+
+	// Detect returns a reason if path has the program of the loader
+	Detect(path string) (string, bool)
+}
+
 type PMMPLoader struct {
 	path     string
 	PHPPath  string
@@ -99,11 +181,11 @@ func (loader *PMMPLoader) Init(path string, options map[string]string) (err erro
 	}
 
 	// check
-	if util.ExistFile(loader.Program()) {
+	if !util.ExistFile(loader.Program()) {
 		return errors.New("Couldn't find php program")
 	}
 
-	if util.ExistFile(loader.Target()) {
+	if !util.ExistFile(loader.Target()) {
 		return errors.New("Couldn't find PMMP program")
 	}
 
@@ -122,6 +204,26 @@ func (loader *PMMPLoader) Cmd() (string, []string) {
 	return loader.Program(), append(loader.Args, loader.Target())
 }
 
+func (PMMPLoader) Detect(path string) (string, bool) {
+	if util.ExistFile(filepath.Join(path, "PocketMine-MP.phar")) {
+		return "found PocketMine-MP.phar", true
+	}
+
+	if util.ExistFile(filepath.Join(path, "src", "pocketmine", "PocketMine.php")) {
+		return "found src/pocketmine", true
+	}
+
+	return "", false
+}
+
+func (PMMPLoader) ReadyPattern() *regexp.Regexp {
+	return DoneReadyPattern
+}
+
+func (PMMPLoader) StopCommand() string {
+	return "stop"
+}
+
 func (PMMPLoader) New() Loader {
 	return new(PMMPLoader)
 }
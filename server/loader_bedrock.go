@@ -0,0 +1,110 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/beito123/mimi/util"
+)
+
+// BedrockReadyPattern matches a line printed when BDS is ready
+var BedrockReadyPattern = regexp.MustCompile(`Server started\.`)
+
+// BedrockLoader is a loader for Bedrock Dedicated Server
+// Options:
+// executable: a server executable, bedrock_server in the program path by default
+type BedrockLoader struct {
+	path           string
+	ExecutablePath string
+}
+
+func (BedrockLoader) Name() string {
+	return "BDS"
+}
+
+func (loader *BedrockLoader) Path() string {
+	return loader.path
+}
+
+func (loader *BedrockLoader) Init(path string, options map[string]string) (err error) {
+	loader.path, err = filepath.Abs(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+
+	executable, ok := options["executable"]
+	if ok {
+		if !filepath.IsAbs(executable) {
+			executable = filepath.Join(loader.path, executable)
+		}
+
+		loader.ExecutablePath = executable
+	} else {
+		if util.IsWin() {
+			loader.ExecutablePath = loader.path + "/bedrock_server.exe"
+		} else {
+			loader.ExecutablePath = loader.path + "/bedrock_server"
+		}
+	}
+
+	// check
+	if !util.ExistFile(loader.ExecutablePath) {
+		return errors.New("Couldn't find bedrock_server")
+	}
+
+	return nil
+}
+
+func (loader *BedrockLoader) Cmd() (string, []string) {
+	return loader.ExecutablePath, nil
+}
+
+// Env returns LD_LIBRARY_PATH for libraries bundled with BDS
+func (loader *BedrockLoader) Env() []string {
+	if util.IsWin() {
+		return nil
+	}
+
+	libs := loader.path
+	if old := os.Getenv("LD_LIBRARY_PATH"); len(old) > 0 {
+		libs += string(os.PathListSeparator) + old
+	}
+
+	return []string{"LD_LIBRARY_PATH=" + libs}
+}
+
+func (BedrockLoader) Detect(path string) (string, bool) {
+	if util.ExistFile(filepath.Join(path, "bedrock_server")) {
+		return "found bedrock_server", true
+	}
+
+	if util.ExistFile(filepath.Join(path, "bedrock_server.exe")) {
+		return "found bedrock_server.exe", true
+	}
+
+	return "", false
+}
+
+func (BedrockLoader) StopCommand() string {
+	return "stop"
+}
+
+// ReadyPattern returns a pattern matching a line printed when the server is ready
+func (BedrockLoader) ReadyPattern() *regexp.Regexp {
+	return BedrockReadyPattern
+}
+
+func (BedrockLoader) New() Loader {
+	return new(BedrockLoader)
+}
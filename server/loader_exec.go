@@ -0,0 +1,144 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/beito123/mimi/util"
+)
+
+// EnvLoader is a Loader having environment variables for its program
+// They are added to the environment of mimi
+type EnvLoader interface {
+	Loader
+
+	Env() []string
+}
+
+// ExecLoader is a loader for any programs
+// Options:
+// command: a program to run (required)
+// args: arguments, can be quoted
+// env: environment variables formatted KEY=VALUE, can be quoted
+// workdir: a working directory, relative to the program path
+// stop_command: a command stopping the program, signals are used if it's empty
+// ready_pattern: a regexp matching a line printed when the program is ready
+type ExecLoader struct {
+	path        string
+	Command     string
+	Args        []string
+	Environment []string
+	Stop        string
+	Ready       *regexp.Regexp
+}
+
+func (ExecLoader) Name() string {
+	return "exec"
+}
+
+func (loader *ExecLoader) Path() string {
+	return loader.path
+}
+
+func (loader *ExecLoader) Init(path string, options map[string]string) (err error) {
+	loader.path, err = filepath.Abs(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+
+	workdir, ok := options["workdir"]
+	if ok {
+		if !filepath.IsAbs(workdir) {
+			workdir = filepath.Join(loader.path, workdir)
+		}
+
+		loader.path = filepath.Clean(workdir)
+	}
+
+	if !util.ExistDir(loader.path) {
+		return errors.New("Couldn't find the working directory")
+	}
+
+	loader.Command, ok = options["command"]
+	if !ok || len(loader.Command) == 0 {
+		return errors.New("command option is required for exec loader")
+	}
+
+	loader.Args, err = util.SplitArgs(options["args"])
+	if err != nil {
+		return err
+	}
+
+	loader.Environment, err = util.SplitArgs(options["env"])
+	if err != nil {
+		return err
+	}
+
+	for _, env := range loader.Environment {
+		if !strings.Contains(env, "=") {
+			return errors.New("env option must be formatted KEY=VALUE")
+		}
+	}
+
+	loader.Stop = options["stop_command"]
+
+	loader.Ready = nil
+
+	if pattern, ok := options["ready_pattern"]; ok && len(pattern) > 0 {
+		loader.Ready, err = regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+	}
+
+	// check
+	if strings.ContainsRune(loader.Command, filepath.Separator) || strings.Contains(loader.Command, "/") {
+		command := loader.Command
+		if !filepath.IsAbs(command) {
+			command = filepath.Join(loader.path, command)
+		}
+
+		if !util.ExistFile(command) {
+			return errors.New("Couldn't find the command")
+		}
+	} else {
+		_, err = exec.LookPath(loader.Command)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (loader *ExecLoader) Cmd() (string, []string) {
+	return loader.Command, loader.Args
+}
+
+func (loader *ExecLoader) Env() []string {
+	return loader.Environment
+}
+
+func (loader *ExecLoader) StopCommand() string {
+	return loader.Stop
+}
+
+func (loader *ExecLoader) ReadyPattern() *regexp.Regexp {
+	return loader.Ready
+}
+
+func (ExecLoader) New() Loader {
+	return new(ExecLoader)
+}
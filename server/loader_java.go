@@ -0,0 +1,263 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/beito123/mimi/util"
+)
+
+// JavaJarNames is patterns of server jars searched in order
+var JavaJarNames = []string{
+	"paper*.jar",
+	"spigot*.jar",
+	"craftbukkit*.jar",
+	"server.jar",
+	"minecraft_server*.jar",
+}
+
+// JavaLoader is a loader for Minecraft Java Edition servers (Vanilla/Paper/Spigot)
+// Options:
+// java: a java binary, $JAVA_HOME/bin/java or java in PATH by default
+// jar: a server jar, found in the program path by default
+// xms, xmx: heap sizes (e.g. 1G)
+// jvm_args: arguments for jvm
+// args: arguments for the server, nogui by default
+// eula: true accepts the EULA by writing eula.txt, false refuses to start without it
+type JavaLoader struct {
+	JarOptions
+
+	path string
+}
+
+func (JavaLoader) Name() string {
+	return "Java"
+}
+
+func (loader *JavaLoader) Path() string {
+	return loader.path
+}
+
+func (loader *JavaLoader) Init(path string, options map[string]string) (err error) {
+	loader.path, err = filepath.Abs(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+
+	err = loader.JarOptions.Init(loader.path, options, JavaJarNames, []string{"nogui"})
+	if err != nil {
+		return err
+	}
+
+	return CheckEULA(loader.path, options["eula"])
+}
+
+func (JavaLoader) Detect(path string) (string, bool) {
+	jar := FindJar(path, JavaJarNames)
+	if len(jar) == 0 {
+		return "", false
+	}
+
+	if util.ExistFile(filepath.Join(path, "server.properties")) {
+		return "found " + filepath.Base(jar) + " and server.properties", true
+	}
+
+	for _, pattern := range JavaJarNames {
+		if ok, _ := filepath.Match(pattern, filepath.Base(jar)); ok {
+			return "found " + filepath.Base(jar), true
+		}
+	}
+
+	return "", false
+}
+
+func (JavaLoader) ReadyPattern() *regexp.Regexp {
+	return DoneReadyPattern
+}
+
+func (JavaLoader) StopCommand() string {
+	return "stop"
+}
+
+func (JavaLoader) New() Loader {
+	return new(JavaLoader)
+}
+
+// JarOptions is a jar run by java, shared by loaders of servers written in Java
+type JarOptions struct {
+	JavaPath string
+	JarPath  string
+	JVMArgs  []string
+	Args     []string
+}
+
+// Init reads java, jar, xms, xmx, jvm_args and args options
+// If jar isn't set, jars are searched in path. If args isn't set, defaultArgs is used
+func (opts *JarOptions) Init(path string, options map[string]string, jars []string, defaultArgs []string) (err error) {
+	opts.JavaPath, err = FindJava(options["java"])
+	if err != nil {
+		return err
+	}
+
+	jar, ok := options["jar"]
+	if ok {
+		if !filepath.IsAbs(jar) {
+			jar = filepath.Join(path, jar)
+		}
+
+		opts.JarPath = jar
+	} else {
+		opts.JarPath = FindJar(path, jars)
+	}
+
+	if len(opts.JarPath) == 0 || !util.ExistFile(opts.JarPath) {
+		return errors.New("Couldn't find a server jar")
+	}
+
+	opts.JVMArgs = nil
+
+	if xms, ok := options["xms"]; ok {
+		opts.JVMArgs = append(opts.JVMArgs, "-Xms"+xms)
+	}
+
+	if xmx, ok := options["xmx"]; ok {
+		opts.JVMArgs = append(opts.JVMArgs, "-Xmx"+xmx)
+	}
+
+	jvmArgs, err := util.SplitArgs(options["jvm_args"])
+	if err != nil {
+		return err
+	}
+
+	opts.JVMArgs = append(opts.JVMArgs, jvmArgs...)
+
+	args, ok := options["args"]
+	if !ok {
+		opts.Args = defaultArgs
+
+		return nil
+	}
+
+	opts.Args, err = util.SplitArgs(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (opts *JarOptions) Cmd() (string, []string) {
+	args := append([]string{}, opts.JVMArgs...)
+	args = append(args, "-jar", opts.JarPath)
+	args = append(args, opts.Args...)
+
+	return opts.JavaPath, args
+}
+
+// FindJava returns a path of a java binary
+// If java is empty, it looks for $JAVA_HOME/bin/java and java in PATH
+func FindJava(java string) (string, error) {
+	if len(java) > 0 {
+		if !util.ExistFile(java) {
+			return exec.LookPath(java)
+		}
+
+		return java, nil
+	}
+
+	name := "java"
+	if util.IsWin() {
+		name = "java.exe"
+	}
+
+	home := os.Getenv("JAVA_HOME")
+	if len(home) > 0 && util.ExistFile(filepath.Join(home, "bin", name)) {
+		return filepath.Join(home, "bin", name), nil
+	}
+
+	path, err := exec.LookPath("java")
+	if err != nil {
+		return "", errors.New("Couldn't find java, set java option or JAVA_HOME")
+	}
+
+	return path, nil
+}
+
+// FindJar returns the first jar in dir matching patterns
+// If nothing matches and dir has only one jar, it returns the jar
+func FindJar(dir string, patterns []string) string {
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		if len(matches) > 0 {
+			return matches[0]
+		}
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.jar"))
+	if len(matches) == 1 {
+		return matches[0]
+	}
+
+	return ""
+}
+
+// CheckEULA checks eula.txt in dir
+// If accept is true, it writes eula=true into eula.txt
+func CheckEULA(dir string, accept string) error {
+	file := filepath.Join(dir, "eula.txt")
+
+	if ok, err := strconv.ParseBool(accept); err == nil && ok {
+		if ReadEULA(file) {
+			return nil
+		}
+
+		return ioutil.WriteFile(file, []byte("# Accepted by mimi\neula=true\n"), 0644)
+	}
+
+	if !ReadEULA(file) {
+		return errors.New("The EULA isn't accepted, set eula option to true or edit eula.txt")
+	}
+
+	return nil
+}
+
+// ReadEULA returns whether eula.txt accepts the EULA
+func ReadEULA(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "eula" {
+			return strings.EqualFold(strings.TrimSpace(kv[1]), "true")
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,57 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckEULA(t *testing.T) {
+	tests := []struct {
+		eula     string // contents of eula.txt, or empty if there's no file
+		accept   string
+		err      bool
+		accepted bool // eula.txt accepts after checking
+	}{
+		{"", "", true, false},
+		{"", "false", true, false},
+		{"", "true", false, true},
+		{"", "yes", true, false},
+		{"#By changing the setting below to TRUE...\neula=false\n", "", true, false},
+		{"#By changing the setting below to TRUE...\neula=false\n", "1", false, true},
+		{"eula=true\n", "", false, true},
+		{" eula = TRUE \n", "false", false, true},
+		{"#eula=true\n", "", true, false},
+		{"eula=truely\n", "", true, false},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		file := filepath.Join(dir, "eula.txt")
+
+		if len(test.eula) > 0 {
+			err := ioutil.WriteFile(file, []byte(test.eula), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := CheckEULA(dir, test.accept)
+		if (err != nil) != test.err {
+			t.Errorf("CheckEULA() with %q and accept %q: error = %v", test.eula, test.accept, err)
+		}
+
+		if got := ReadEULA(file); got != test.accepted {
+			t.Errorf("ReadEULA() after checking %q with accept %q = %v, want %v", test.eula, test.accept, got, test.accepted)
+		}
+	}
+}
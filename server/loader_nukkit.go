@@ -0,0 +1,83 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"path/filepath"
+	"regexp"
+
+	"github.com/beito123/mimi/util"
+)
+
+// NukkitJarNames is patterns of Nukkit jars searched in order
+var NukkitJarNames = []string{
+	"powernukkit*.jar",
+	"nukkit*.jar",
+	"PowerNukkit*.jar",
+	"Nukkit*.jar",
+}
+
+// NukkitLoader is a loader for Nukkit and PowerNukkit
+// Options:
+// java: a java binary, $JAVA_HOME/bin/java or java in PATH by default
+// jar: a server jar, found in the program path by default
+// xms, xmx: heap sizes (e.g. 1G)
+// jvm_args: arguments for jvm
+// args: arguments for the server
+type NukkitLoader struct {
+	JarOptions
+
+	path string
+}
+
+func (NukkitLoader) Name() string {
+	return "Nukkit"
+}
+
+func (loader *NukkitLoader) Path() string {
+	return loader.path
+}
+
+func (loader *NukkitLoader) Init(path string, options map[string]string) (err error) {
+	loader.path, err = filepath.Abs(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+
+	return loader.JarOptions.Init(loader.path, options, NukkitJarNames, nil)
+}
+
+func (NukkitLoader) Detect(path string) (string, bool) {
+	for _, pattern := range NukkitJarNames {
+		matches, _ := filepath.Glob(filepath.Join(path, pattern))
+		if len(matches) > 0 {
+			return "found " + filepath.Base(matches[0]), true
+		}
+	}
+
+	if util.ExistFile(filepath.Join(path, "nukkit.yml")) && len(FindJar(path, nil)) > 0 {
+		return "found nukkit.yml and a jar", true
+	}
+
+	return "", false
+}
+
+func (NukkitLoader) StopCommand() string {
+	return "stop"
+}
+
+// ReadyPattern returns a pattern matching a line printed when the server is ready
+func (NukkitLoader) ReadyPattern() *regexp.Regexp {
+	return DoneReadyPattern
+}
+
+func (NukkitLoader) New() Loader {
+	return new(NukkitLoader)
+}
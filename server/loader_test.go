@@ -0,0 +1,71 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoaderManagerDetect(t *testing.T) {
+	tests := []struct {
+		files  []string
+		loader string // empty if nothing is detected
+		reason string
+	}{
+		{nil, "", ""},
+		{[]string{"PocketMine-MP.phar"}, "PMMP", "found PocketMine-MP.phar"},
+		{[]string{"src/pocketmine/PocketMine.php"}, "PMMP", "found src/pocketmine"},
+		{[]string{"bedrock_server"}, "BDS", "found bedrock_server"},
+		{[]string{"bedrock_server.exe"}, "BDS", "found bedrock_server.exe"},
+		{[]string{"nukkit-1.0.jar"}, "Nukkit", "found nukkit-1.0.jar"},
+		{[]string{"PowerNukkit-1.5.jar"}, "Nukkit", "found PowerNukkit-1.5.jar"},
+		{[]string{"nukkit.yml", "server-1.jar"}, "Nukkit", "found nukkit.yml and a jar"},
+		{[]string{"paper-1.20.jar"}, "Java", "found paper-1.20.jar"},
+		{[]string{"server.jar"}, "Java", "found server.jar"},
+		{[]string{"custom.jar", "server.properties"}, "Java", "found custom.jar and server.properties"},
+		{[]string{"custom.jar"}, "", ""},
+		{[]string{"a.jar", "b.jar", "server.properties"}, "", ""},
+		{[]string{"PocketMine-MP.phar", "server.jar"}, "PMMP", "found PocketMine-MP.phar"}, // the first registered
+	}
+
+	lm := NewLoaderManager(&PMMPLoader{}, &BedrockLoader{}, &NukkitLoader{}, &JavaLoader{}, &ExecLoader{})
+
+	for _, test := range tests {
+		dir := t.TempDir()
+
+		for _, name := range test.files {
+			file := filepath.Join(dir, filepath.FromSlash(name))
+
+			err := os.MkdirAll(filepath.Dir(file), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ioutil.WriteFile(file, nil, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		loader, reason, ok := lm.Detect(dir)
+		if ok != (len(test.loader) > 0) {
+			t.Errorf("Detect() with %v ok = %v", test.files, ok)
+
+			continue
+		}
+
+		if ok && (loader.Name() != test.loader || reason != test.reason) {
+			t.Errorf("Detect() with %v = %s (%s), want %s (%s)", test.files, loader.Name(), reason, test.loader, test.reason)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/beito123/mimi/util"
+)
+
+// LockFileName is a name of a lock file taken in a program directory
+const LockFileName = "mimi.lock"
+
+var errLocked = errors.New("the program is locked by another process")
+
+// TryLock creates a lock file having the pid of mimi
+// A lock file left by a dead process is taken over,
+// unless a detached program started by the process is still running and adopt is false
+func TryLock(file string, adopt bool) error {
+	for i := 0; i < 2; i++ {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+			f.Close()
+
+			return err
+		}
+
+		if !os.IsExist(err) {
+			return err
+		}
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err == nil && util.ProcessExists(pid) {
+			return errLocked
+		}
+
+		// the lock has the pid of mimi, not of the program
+		if !adopt && detachedRunning(filepath.Dir(file)) {
+			return errLocked
+		}
+
+		logger.Warnf("Removed a stale lock file %s", file)
+
+		os.Remove(file)
+	}
+
+	return errLocked
+}
+
+// detachedRunning returns whether a detached program saved in dir is running
+func detachedRunning(dir string) bool {
+	state, err := LoadDetachState(dir)
+
+	return err == nil && state.Alive()
+}
+
+// Unlock removes a lock file
+func Unlock(file string) error {
+	return os.Remove(file)
+}
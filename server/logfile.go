@@ -0,0 +1,482 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beito123/mimi/pks"
+	"github.com/beito123/mimi/util"
+)
+
+const (
+	// DefaultLogMaxSize is size of a log file before rotated
+	DefaultLogMaxSize = 10 * 1024 * 1024
+
+	LogDateFormat = "2006-01-02"
+	LogTimeFormat = "2006-01-02 15:04:05.000"
+
+	// LogParseFormat parses times of LogTimeFormat and ones without milliseconds written by older versions
+	LogParseFormat = "2006-01-02 15:04:05"
+)
+
+// LogFile writes lines to files named by date in Dir
+// A file is rotated when the date changes or it exceeds MaxSize,
+// and old files are compressed with gzip in background
+type LogFile struct {
+	Dir     string
+	MaxSize int64
+	MaxAge  time.Duration // 0 keeps old files forever
+
+	file   *os.File
+	date   string
+	size   int64
+	closed bool
+	mutex  sync.Mutex
+
+	compressing   sync.WaitGroup
+	compressMutex sync.Mutex // serializes compression
+}
+
+var errLogClosed = errors.New("the log file is closed")
+
+// NewLogFile returns a LogFile writing to dir
+func NewLogFile(dir string, maxSize int64, maxAge time.Duration) (*LogFile, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxSize <= 0 {
+		maxSize = DefaultLogMaxSize
+	}
+
+	return &LogFile{
+		Dir:     dir,
+		MaxSize: maxSize,
+		MaxAge:  maxAge,
+	}, nil
+}
+
+// Write writes a line of a console tagged tag with the time of the line
+func (lf *LogFile) Write(tag string, line *Line) error {
+	lf.mutex.Lock()
+	defer lf.mutex.Unlock()
+
+	if lf.closed {
+		return errLogClosed
+	}
+
+	now := line.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	err := lf.rotate(now)
+	if err != nil {
+		return err
+	}
+
+	stream := "out"
+	if line.Stream == pks.StreamStderr {
+		stream = "err"
+	}
+
+	n, err := fmt.Fprintf(lf.file, "%s %s:%d [%s] %s\n", now.Format(LogTimeFormat), tag, line.Seq, stream, line.Text)
+	lf.size += int64(n)
+
+	return err
+}
+
+// Close closes the current file and waits for compression, lines aren't written after closed
+func (lf *LogFile) Close() error {
+	lf.mutex.Lock()
+	defer lf.mutex.Unlock()
+
+	lf.closed = true
+
+	var err error
+	if lf.file != nil {
+		err = lf.file.Close()
+		lf.file = nil
+	}
+
+	lf.compressing.Wait()
+
+	return err
+}
+
+func (lf *LogFile) path(date string) string {
+	return filepath.Join(lf.Dir, date+".log")
+}
+
+// rotate opens a file for now, and rotates the current file if needed
+func (lf *LogFile) rotate(now time.Time) error {
+	date := now.Format(LogDateFormat)
+
+	if lf.file != nil && lf.date == date && lf.size < lf.MaxSize {
+		return nil
+	}
+
+	if lf.file != nil {
+		lf.file.Close()
+		lf.file = nil
+	}
+
+	// the file of the day may be left by a previous console
+	if lf.date != date {
+		lf.background(func() {
+			lf.compressOld(date)
+		})
+	}
+
+	path := lf.path(date)
+
+	info, err := os.Stat(path)
+	if err == nil && info.Size() >= lf.MaxSize {
+		err = lf.archive(path, date)
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err = file.Stat()
+	if err != nil {
+		file.Close()
+
+		return err
+	}
+
+	lf.file = file
+	lf.date = date
+	lf.size = info.Size()
+
+	return nil
+}
+
+// archive renames a full file of date to <date>.<n>.log and compresses it
+func (lf *LogFile) archive(path string, date string) error {
+	var archived string
+	for n := 1; ; n++ {
+		archived = filepath.Join(lf.Dir, fmt.Sprintf("%s.%d.log", date, n))
+
+		if !util.ExistFile(archived) && !util.ExistFile(archived+".gz") {
+			break
+		}
+	}
+
+	err := os.Rename(path, archived)
+	if err != nil {
+		return err
+	}
+
+	lf.background(func() {
+		lf.compress(archived)
+	})
+
+	return nil
+}
+
+// background runs fn without blocking Write, compressing a large file takes a while
+func (lf *LogFile) background(fn func()) {
+	lf.compressing.Add(1)
+
+	go func() {
+		defer lf.compressing.Done()
+
+		lf.compressMutex.Lock()
+		defer lf.compressMutex.Unlock()
+
+		fn()
+	}()
+}
+
+// compressOld compresses files of other days than date, and removes expired files
+func (lf *LogFile) compressOld(date string) {
+	matches, err := filepath.Glob(filepath.Join(lf.Dir, "*.log"))
+	if err != nil {
+		return
+	}
+
+	for _, path := range matches {
+		if strings.HasPrefix(filepath.Base(path), date) {
+			continue
+		}
+
+		lf.compress(path)
+	}
+
+	if lf.MaxAge <= 0 {
+		return
+	}
+
+	matches, err = filepath.Glob(filepath.Join(lf.Dir, "*.log.gz"))
+	if err != nil {
+		return
+	}
+
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err == nil && time.Since(info.ModTime()) > lf.MaxAge {
+			os.Remove(path)
+		}
+	}
+}
+
+// compress compresses path to path.gz and removes path
+// The file is written to path.gz.tmp first, so readers don't see a broken file
+func (lf *LogFile) compress(path string) {
+	if util.ExistFile(path + ".gz") {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	tmp := path + ".gz.tmp"
+
+	err = gzipFile(path, tmp)
+	if err != nil {
+		logger.Errorf("Couldn't compress a log file %s: %s", path, err.Error())
+
+		os.Remove(tmp)
+
+		return
+	}
+
+	// keep the time of the last line for expiration
+	os.Chtimes(tmp, info.ModTime(), info.ModTime())
+
+	err = os.Rename(tmp, path+".gz")
+	if err != nil {
+		logger.Errorf("Couldn't compress a log file %s: %s", path, err.Error())
+
+		os.Remove(tmp)
+
+		return
+	}
+
+	os.Remove(path)
+}
+
+func gzipFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	writer := gzip.NewWriter(out)
+
+	_, err = io.Copy(writer, in)
+	if err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// History returns n lines at most of a console tagged tag before seq, from old to new
+// If seq is 0, it returns the last lines. Files older than the date of since aren't read
+func (lf *LogFile) History(tag string, seq int64, n int, since time.Time) ([]*Line, error) {
+	return lf.Find(tag, n, since, func(line *Line) bool {
+		return seq <= 0 || line.Seq < seq
+	})
+}
+
+// Find returns the last n lines at most of a console tagged tag matching match, from old to new
+// Files older than the date of since aren't read
+func (lf *LogFile) Find(tag string, n int, since time.Time, match func(line *Line) bool) ([]*Line, error) {
+	var lines []*Line
+
+	for _, file := range lf.files(since.Format(LogDateFormat)) {
+		found, err := readLogFile(file.path, tag, match)
+		if os.IsNotExist(err) { // compressed or removed meanwhile
+			continue
+		} else if err != nil {
+			return lines, err
+		}
+
+		lines = append(found, lines...)
+
+		if len(lines) >= n {
+			return lines[len(lines)-n:], nil
+		}
+
+		if len(lines) > 0 && lines[0].Seq <= 1 {
+			break
+		}
+	}
+
+	return lines, nil
+}
+
+type logFileName struct {
+	path string
+	date string
+	n    int // number of a rotated file, math.MaxInt32 for the current file
+}
+
+// files returns log files from the date of since, from new to old
+func (lf *LogFile) files(since string) []*logFileName {
+	matches, err := filepath.Glob(filepath.Join(lf.Dir, "*.log*"))
+	if err != nil {
+		return nil
+	}
+
+	var files []*logFileName
+	for _, path := range matches {
+		name := strings.TrimSuffix(filepath.Base(path), ".gz")
+		if !strings.HasSuffix(name, ".log") {
+			continue
+		}
+
+		// a file is removed just after compressed
+		if path != filepath.Join(lf.Dir, name) && util.ExistFile(filepath.Join(lf.Dir, name)) {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimSuffix(name, ".log"), ".")
+
+		file := &logFileName{
+			path: path,
+			date: parts[0],
+			n:    math.MaxInt32,
+		}
+
+		if len(parts) > 1 {
+			file.n, err = strconv.Atoi(parts[1])
+			if err != nil {
+				continue
+			}
+		}
+
+		if file.date < since {
+			continue
+		}
+
+		files = append(files, file)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].date != files[j].date {
+			return files[i].date > files[j].date
+		}
+
+		return files[i].n > files[j].n
+	})
+
+	return files
+}
+
+// readLogFile returns lines of a console tagged tag matching match in a file
+func readLogFile(path string, tag string, match func(line *Line) bool) ([]*Line, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+
+		defer gz.Close()
+
+		reader = gz
+	}
+
+	var lines []*Line
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		t, line, ok := parseLogLine(scanner.Text())
+		if !ok || t != tag || !match(line) {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseLogLine parses a line written by Write and returns the tag
+func parseLogLine(str string) (string, *Line, bool) {
+	// date time tag:seq [stream] text
+	parts := strings.SplitN(str, " ", 5)
+	if len(parts) != 5 {
+		return "", nil, false
+	}
+
+	t, err := time.ParseInLocation(LogParseFormat, parts[0]+" "+parts[1], time.Local)
+	if err != nil {
+		return "", nil, false
+	}
+
+	id := strings.SplitN(parts[2], ":", 2)
+	if len(id) != 2 {
+		return "", nil, false
+	}
+
+	seq, err := strconv.ParseInt(id[1], 10, 64)
+	if err != nil {
+		return "", nil, false
+	}
+
+	line := &Line{
+		Seq:  seq,
+		Time: t,
+		Text: parts[4],
+	}
+
+	switch parts[3] {
+	case "[out]":
+		line.Stream = pks.StreamStdout
+	case "[err]":
+		line.Stream = pks.StreamStderr
+	default:
+		return "", nil, false
+	}
+
+	return id[0], line, true
+}
@@ -0,0 +1,176 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/beito123/mimi/pks"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		str  string
+		tag  string
+		line *Line
+	}{
+		{
+			"2026-10-18 12:34:56.789 abcd1234:42 [out] Done (0.3s)!",
+			"abcd1234",
+			&Line{Seq: 42, Stream: pks.StreamStdout, Text: "Done (0.3s)!",
+				Time: time.Date(2026, 10, 18, 12, 34, 56, 789000000, time.Local)},
+		},
+		{ // written before milliseconds were added
+			"2026-10-18 12:34:56 abcd1234:7 [err] oops",
+			"abcd1234",
+			&Line{Seq: 7, Stream: pks.StreamStderr, Text: "oops",
+				Time: time.Date(2026, 10, 18, 12, 34, 56, 0, time.Local)},
+		},
+		{
+			"2026-10-18 12:34:56.000 abcd1234:1 [out] ",
+			"abcd1234",
+			&Line{Seq: 1, Stream: pks.StreamStdout, Text: "",
+				Time: time.Date(2026, 10, 18, 12, 34, 56, 0, time.Local)},
+		},
+		{"2026-10-18 12:34:56.000 abcd1234:1 [out]", "", nil},
+		{"2026-10-18 12:34:56.000 abcd1234 [out] no seq", "", nil},
+		{"2026-10-18 12:34:56.000 abcd1234:x [out] bad seq", "", nil},
+		{"2026-10-18 12:34:56.000 abcd1234:1 [in] bad stream", "", nil},
+		{"yesterday noon abcd1234:1 [out] bad time", "", nil},
+	}
+
+	for _, test := range tests {
+		tag, line, ok := parseLogLine(test.str)
+		if ok != (test.line != nil) {
+			t.Errorf("parseLogLine(%q) ok = %v", test.str, ok)
+
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		if tag != test.tag || !reflect.DeepEqual(line, test.line) {
+			t.Errorf("parseLogLine(%q) = %s %+v, want %s %+v", test.str, tag, line, test.tag, test.line)
+		}
+	}
+}
+
+func TestLogFileWrite(t *testing.T) {
+	lf, err := NewLogFile(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lf.Close()
+
+	now := time.Now()
+
+	var want []*Line
+	for i, text := range []string{"first", "with  spaces", "", "[err] like"} {
+		line := &Line{
+			Seq:  int64(i + 1),
+			Time: now.Add(time.Duration(i) * time.Millisecond),
+			Text: text,
+		}
+
+		if i%2 == 1 {
+			line.Stream = pks.StreamStderr
+		}
+
+		err = lf.Write("tag1", line)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// lines of another console in the same file
+		err = lf.Write("tag2", &Line{Seq: line.Seq, Time: line.Time, Text: "other"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want = append(want, line)
+	}
+
+	got, err := lf.History("tag1", 0, 10, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("History() returned %d lines, want %d", len(got), len(want))
+	}
+
+	for i, line := range got {
+		w := want[i]
+		if line.Seq != w.Seq || line.Stream != w.Stream || line.Text != w.Text || !line.Time.Equal(w.Time.Truncate(time.Millisecond)) {
+			t.Errorf("History()[%d] = %+v, want %+v", i, line, w)
+		}
+	}
+
+	got, err = lf.History("tag1", 3, 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || got[0].Seq != 2 {
+		t.Errorf("History(seq 3, 1 line) = %v, want [2]", seqs(got))
+	}
+}
+
+func TestLogFileFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	names := []string{
+		"2026-10-16.log",
+		"2026-10-17.1.log.gz",
+		"2026-10-17.log.gz",
+		"2026-10-18.1.log.gz",
+		"2026-10-18.2.log",
+		"2026-10-18.2.log.gz", // compressed just now
+		"2026-10-18.3.log.gz.tmp",
+		"2026-10-18.log",
+		"2026-10-18.x.log",
+		"notes.txt",
+	}
+
+	for _, name := range names {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lf := &LogFile{
+		Dir: dir,
+	}
+
+	var got []string
+	for _, file := range lf.files("2026-10-17") {
+		got = append(got, filepath.Base(file.path))
+	}
+
+	want := []string{
+		"2026-10-18.log",
+		"2026-10-18.2.log",
+		"2026-10-18.1.log.gz",
+		"2026-10-17.log.gz",
+		"2026-10-17.1.log.gz",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("files() = %v, want %v", got, want)
+	}
+}
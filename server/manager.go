@@ -14,15 +14,24 @@ import (
 	"errors"
 	"time"
 
+	"github.com/beito123/mimi/pks"
 	"github.com/gorilla/websocket"
 	cmap "github.com/orcaman/concurrent-map"
 	uuid "github.com/satori/go.uuid"
-	"github.com/beito123/mimi/pks"
 
 	"github.com/beito123/mimi"
 )
 
+func NewSessionManager(uid uuid.UUID, handlers ...mimi.PacketHandler) *SessionManager {
+	return &SessionManager{
+		UUID:     uid,
+		Handlers: handlers,
+		sessions: cmap.New(),
+	}
+}
+
 type SessionManager struct {
+	UUID     uuid.UUID // Server's UUID
 	Handlers []mimi.PacketHandler
 
 	sessions cmap.ConcurrentMap // map[uuid.UUID]Session
@@ -46,6 +55,10 @@ func (sm *SessionManager) setSession(session mimi.Session) {
 	sm.sessions.Set(session.UUID().String(), session)
 }
 
+func (sm *SessionManager) removeSession(session mimi.Session) {
+	sm.sessions.Remove(session.UUID().String())
+}
+
 func (sm *SessionManager) rangeSessions(f func(session mimi.Session) bool) {
 	for item := range sm.sessions.IterBuffered() {
 		session, ok := item.Val.(mimi.Session)
@@ -72,6 +85,12 @@ func (sm *SessionManager) Start(ctx context.Context) {
 		}
 
 		sm.rangeSessions(func(session mimi.Session) bool {
+			if session.State() == mimi.StateDisconnected {
+				sm.closeSession(session)
+
+				return true
+			}
+
 			session.Update(sm.Handlers)
 
 			return true
@@ -79,15 +98,28 @@ func (sm *SessionManager) Start(ctx context.Context) {
 	}
 }
 
+func (sm *SessionManager) closeSession(session mimi.Session) {
+	serSession, ok := session.(*ServerSession)
+	if ok && serSession.HasJoined() {
+		serSession.QuitConsole()
+	}
+
+	sm.removeSession(session)
+
+	logger.Debugf("Closed a session(%s)", session.Addr().String())
+}
+
 func (sm *SessionManager) closeSessions() {
 	sm.rangeSessions(func(session mimi.Session) bool {
 		session.Close()
+		sm.closeSession(session)
 
 		return true
 	})
 }
 
-func (sm *SessionManager) NewSession(conn *websocket.Conn) error {
+// NewSession starts a session of an authenticated user
+func (sm *SessionManager) NewSession(conn *websocket.Conn, user *User) error {
 	uid, err := uuid.NewV4()
 	if err != nil {
 		return err
@@ -95,12 +127,23 @@ func (sm *SessionManager) NewSession(conn *websocket.Conn) error {
 
 	session := &ServerSession{
 		BaseSession: mimi.NewBaseSession(conn, uid, uuid.Nil),
+		User:        user,
 	}
 
 	sm.setSession(session)
 
 	session.Start()
 
+	err = session.SendPacket(&pks.ConnectionOne{
+		UUID: sm.UUID,
+		Time: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("Accepted a new session(%s) of %s (%s)", session.Addr().String(), user.Name, user.Role.String())
+
 	return nil
 }
 
@@ -112,16 +155,3 @@ func (sm *SessionManager) SendPacket(uid uuid.UUID, pk pks.Packet) error {
 
 	return session.SendPacket(pk)
 }
-
-func (sm *SessionManager) SendPacketAll(pk pks.Packet) (err error) {
-	sm.rangeSessions(func(session mimi.Session) bool {
-		err = session.SendPacket(pk)
-		if err != nil {
-			return false
-		}
-
-		return true
-	})
-
-	return err
-}
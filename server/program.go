@@ -11,15 +11,70 @@ package server
 
 import (
 	"errors"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/beito123/mimi/config"
+	"github.com/beito123/mimi/util"
+	uuid "github.com/satori/go.uuid"
 )
 
 var RegOnlyAlphabetNumber = regexp.MustCompile("^[a-zA-Z0-9]+$")
 
-func NewProgramManager(lm *LoaderManager, programs []config.ProgramConfig) (*ProgramManager, error) {
+// DefaultStopTimeout is time to wait for a program after sending the stop command
+const DefaultStopTimeout = 30 * time.Second
+
+const (
+	DefaultMaxRetries   = 5
+	DefaultRestartDelay = time.Second
+	MaxRestartDelay     = 5 * time.Minute
+
+	// RestartResetTime is time to run for a program to reset the retry count
+	RestartResetTime = 10 * time.Minute
+)
+
+// RestartPolicy decides whether a program is restarted after it exited by itself
+type RestartPolicy int
+
+const (
+	RestartNever RestartPolicy = iota
+	RestartOnFailure
+	RestartAlways
+)
+
+// ParseRestartPolicy returns a policy from never, on-failure or always
+func ParseRestartPolicy(str string) (RestartPolicy, error) {
+	switch strings.ToLower(str) {
+	case "", "never", "no":
+		return RestartNever, nil
+	case "on-failure":
+		return RestartOnFailure, nil
+	case "always":
+		return RestartAlways, nil
+	}
+
+	return RestartNever, errors.New("unknown restart policy: " + str)
+}
+
+// Should returns whether a program exited with err should be restarted
+func (policy RestartPolicy) Should(err error) bool {
+	switch policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	}
+
+	return false
+}
+
+// NewProgramManager returns a ProgramManager having programs
+// Log files of programs are saved in dataDir
+func NewProgramManager(lm *LoaderManager, dataDir string, programs []config.ProgramConfig) (*ProgramManager, error) {
 	pm := &ProgramManager{
 		Programs: make(map[string]*Program),
 	}
@@ -32,9 +87,20 @@ func NewProgramManager(lm *LoaderManager, programs []config.ProgramConfig) (*Pro
 			return nil, errors.New("can't use for program's name except alphabets and numbers")
 		}
 
-		loader, ok := lm.Get(pc.Loader)
+		loader, ok := lm.Find(pc.Loader)
 		if !ok {
-			return nil, errors.New("couldn't find a loader")
+			if len(pc.Loader) > 0 {
+				logger.Warnf("Couldn't find a loader \"%s\" for %s, detecting from the directory", pc.Loader, name)
+			}
+
+			var reason string
+
+			loader, reason, ok = lm.Detect(pc.Path)
+			if !ok {
+				return nil, errors.New("couldn't find a loader for " + name)
+			}
+
+			logger.Infof("Detected a loader %s for %s (%s)", loader.Name(), name, reason)
 		}
 
 		err := loader.Init(pc.Path, pc.LoaderOptions)
@@ -42,10 +108,57 @@ func NewProgramManager(lm *LoaderManager, programs []config.ProgramConfig) (*Pro
 			return nil, err
 		}
 
-		pm.Add(&Program{
-			Name:   name,
-			Loader: loader,
-		})
+		stopTimeout := DefaultStopTimeout
+		if pc.StopTimeout > 0 {
+			stopTimeout = time.Duration(pc.StopTimeout) * time.Second
+		}
+
+		var pty bool
+		if opt, ok := pc.LoaderOptions["pty"]; ok {
+			pty, err = strconv.ParseBool(opt)
+			if err != nil {
+				return nil, errors.New("invalid pty option of " + name)
+			}
+		}
+
+		if pc.Detached && (pty || util.IsWin()) {
+			return nil, errors.New("detached can't be used with pty or on windows: " + name)
+		}
+
+		restart, err := ParseRestartPolicy(pc.Restart)
+		if err != nil {
+			return nil, errors.New("invalid restart option of " + name)
+		}
+
+		maxRetries := DefaultMaxRetries
+		if pc.MaxRetries != nil {
+			maxRetries = *pc.MaxRetries
+		}
+
+		restartDelay := DefaultRestartDelay
+		if pc.RestartDelay > 0 {
+			restartDelay = time.Duration(pc.RestartDelay) * time.Second
+		}
+
+		program := &Program{
+			Name:         name,
+			Loader:       loader,
+			StopTimeout:  stopTimeout,
+			PTY:          pty,
+			Restart:      restart,
+			MaxRetries:   maxRetries,
+			RestartDelay: restartDelay,
+			LockFile:     pc.LockFile,
+			Detached:     pc.Detached,
+		}
+
+		if pc.Log.Enabled {
+			program.LogDir = filepath.Join(dataDir, "logs", name)
+			program.LogMaxSize = int64(pc.Log.MaxSize) * 1024 * 1024
+			program.LogMaxAge = time.Duration(pc.Log.MaxAge) * 24 * time.Hour
+		}
+
+		pm.Add(program)
 	}
 
 	return pm, nil
@@ -70,6 +183,123 @@ func (pm *ProgramManager) Remove(name string) {
 }
 
 type Program struct {
-	Name   string
-	Loader Loader
+	Name        string
+	Loader      Loader
+	StopTimeout time.Duration
+	PTY         bool // runs on a pseudo-terminal
+
+	Restart      RestartPolicy
+	MaxRetries   int // negative for unlimited
+	RestartDelay time.Duration
+
+	// LockFile takes a lock file while the program is running,
+	// so other mimi can't start the program in the same directory
+	LockFile bool
+
+	// Detached runs the program surviving mimi, it's adopted by the next mimi
+	Detached bool
+
+	// LogDir is a directory saving log files, empty if disabled
+	LogDir     string
+	LogMaxSize int64
+	LogMaxAge  time.Duration
+
+	console     *Console
+	lastExit    *Exit
+	lastConsole uuid.UUID
+	mutex       sync.Mutex
+}
+
+var errProgramRunning = errors.New("the program is already running")
+
+// Console returns a running console of the program
+func (program *Program) Console() (*Console, bool) {
+	program.mutex.Lock()
+	defer program.mutex.Unlock()
+
+	if program.console == nil || program.console.Closed() {
+		return nil, false
+	}
+
+	return program.console, true
+}
+
+// attach sets con as the running console of the program
+// It fails if another console is running or the lock file is taken
+// adopt is true if con adopts a detached program left by another mimi
+func (program *Program) attach(con *Console, adopt bool) error {
+	program.mutex.Lock()
+	defer program.mutex.Unlock()
+
+	if program.console == con {
+		return nil
+	}
+
+	if program.console != nil && !program.console.Closed() {
+		return errProgramRunning
+	}
+
+	if program.LockFile {
+		err := TryLock(program.lockFile(), adopt)
+		if err != nil {
+			return err
+		}
+	}
+
+	program.console = con
+
+	return nil
+}
+
+// detach removes con after it's closed
+func (program *Program) detach(con *Console) {
+	program.mutex.Lock()
+	defer program.mutex.Unlock()
+
+	if program.console != con {
+		return
+	}
+
+	program.console = nil
+
+	if program.LockFile {
+		err := Unlock(program.lockFile())
+		if err != nil {
+			logger.Errorf("Couldn't remove a lock file of %s: %s", program.Name, err.Error())
+		}
+	}
+}
+
+func (program *Program) lockFile() string {
+	return filepath.Join(program.Loader.Path(), LockFileName)
+}
+
+// LastExit returns the last exit of the program and its console
+func (program *Program) LastExit() (uuid.UUID, *Exit) {
+	program.mutex.Lock()
+	defer program.mutex.Unlock()
+
+	return program.lastConsole, program.lastExit
+}
+
+func (program *Program) SetLastExit(uid uuid.UUID, exit *Exit) {
+	program.mutex.Lock()
+	defer program.mutex.Unlock()
+
+	program.lastConsole = uid
+	program.lastExit = exit
+}
+
+// Backoff returns time to wait before the retry-th restart
+func (program *Program) Backoff(retry int) time.Duration {
+	delay := program.RestartDelay
+	for i := 0; i < retry && delay < MaxRestartDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > MaxRestartDelay {
+		delay = MaxRestartDelay
+	}
+
+	return delay
 }
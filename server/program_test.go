@@ -0,0 +1,127 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/beito123/mimi/config"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		str    string
+		policy RestartPolicy
+		err    bool
+	}{
+		{"", RestartNever, false},
+		{"never", RestartNever, false},
+		{"no", RestartNever, false},
+		{"on-failure", RestartOnFailure, false},
+		{"On-Failure", RestartOnFailure, false},
+		{"always", RestartAlways, false},
+		{"sometimes", RestartNever, true},
+	}
+
+	for _, test := range tests {
+		policy, err := ParseRestartPolicy(test.str)
+		if (err != nil) != test.err || policy != test.policy {
+			t.Errorf("ParseRestartPolicy(%q) = %d, %v", test.str, policy, err)
+		}
+	}
+}
+
+func TestRestartPolicyShould(t *testing.T) {
+	failed := errors.New("exit status 1")
+
+	tests := []struct {
+		policy RestartPolicy
+		exited bool // exited with code 0
+		failed bool
+	}{
+		{RestartNever, false, false},
+		{RestartOnFailure, false, true},
+		{RestartAlways, true, true},
+	}
+
+	for _, test := range tests {
+		if got := test.policy.Should(nil); got != test.exited {
+			t.Errorf("policy %d: Should(nil) = %v", test.policy, got)
+		}
+
+		if got := test.policy.Should(failed); got != test.failed {
+			t.Errorf("policy %d: Should(failed) = %v", test.policy, got)
+		}
+	}
+}
+
+func TestProgramBackoff(t *testing.T) {
+	tests := []struct {
+		delay time.Duration
+		retry int
+		want  time.Duration
+	}{
+		{time.Second, 0, time.Second},
+		{time.Second, 1, 2 * time.Second},
+		{time.Second, 4, 16 * time.Second},
+		{time.Second, 8, 256 * time.Second},
+		{time.Second, 9, MaxRestartDelay},
+		{time.Second, 1000, MaxRestartDelay},
+		{10 * time.Minute, 0, MaxRestartDelay},
+	}
+
+	for _, test := range tests {
+		program := &Program{
+			RestartDelay: test.delay,
+		}
+
+		if got := program.Backoff(test.retry); got != test.want {
+			t.Errorf("Backoff(%d) with %s = %s, want %s", test.retry, test.delay, got, test.want)
+		}
+	}
+}
+
+func TestProgramMaxRetries(t *testing.T) {
+	zero, three, unlimited := 0, 3, -1
+
+	tests := []struct {
+		maxRetries *int
+		want       int
+	}{
+		{nil, DefaultMaxRetries},
+		{&zero, 0},
+		{&three, 3},
+		{&unlimited, -1},
+	}
+
+	for _, test := range tests {
+		pm, err := NewProgramManager(NewLoaderManager(&ExecLoader{}), t.TempDir(), []config.ProgramConfig{{
+			Name:          "test",
+			Path:          t.TempDir(),
+			Loader:        "exec",
+			LoaderOptions: map[string]string{"command": "sh"},
+			MaxRetries:    test.maxRetries,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		program, ok := pm.Get("test")
+		if !ok {
+			t.Fatal("couldn't get the program")
+		}
+
+		if program.MaxRetries != test.want {
+			t.Errorf("MaxRetries = %d, want %d", program.MaxRetries, test.want)
+		}
+	}
+}
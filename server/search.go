@@ -0,0 +1,81 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/beito123/mimi/color"
+	"github.com/beito123/mimi/pks"
+)
+
+// MaxSearchResults is the number of lines searched at once at most
+const MaxSearchResults = 1000
+
+// DefaultSearchResults is the number of lines searched for a request without MaxResults
+const DefaultSearchResults = 100
+
+// LineFilter is a condition of lines searched
+type LineFilter struct {
+	Text   string         // a substring, used if Regexp is nil
+	Regexp *regexp.Regexp // a pattern
+	Since  time.Time      // zero for no limit
+	Until  time.Time      // zero for no limit
+	Stream byte           // pks.StreamAll for all streams
+}
+
+// NewLineFilter returns a filter from a query
+// If regex is true, query is compiled as a regular expression
+func NewLineFilter(query string, regex bool) (*LineFilter, error) {
+	filter := &LineFilter{
+		Stream: pks.StreamAll,
+	}
+
+	if !regex {
+		filter.Text = query
+
+		return filter, nil
+	}
+
+	var err error
+
+	filter.Regexp, err = regexp.Compile(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// Match returns whether line matches the filter
+// Text is matched without colors
+func (filter *LineFilter) Match(line *Line) bool {
+	if filter.Stream != pks.StreamAll && filter.Stream != line.Stream {
+		return false
+	}
+
+	if !filter.Since.IsZero() && line.Time.Before(filter.Since) {
+		return false
+	}
+
+	if !filter.Until.IsZero() && line.Time.After(filter.Until) {
+		return false
+	}
+
+	text := color.Strip(line.Text)
+
+	if filter.Regexp != nil {
+		return filter.Regexp.MatchString(text)
+	}
+
+	return strings.Contains(text, filter.Text)
+}
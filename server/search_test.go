@@ -0,0 +1,137 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/beito123/mimi/pks"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestLineFilterMatch(t *testing.T) {
+	now := time.Now()
+
+	line := &Line{
+		Time:   now,
+		Stream: pks.StreamStderr,
+		Text:   "\x1b[31m§cPlayer joined\x1b[0m",
+	}
+
+	regex := func(query string) *LineFilter {
+		filter, err := NewLineFilter(query, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return filter
+	}
+
+	tests := []struct {
+		filter *LineFilter
+		want   bool
+	}{
+		{&LineFilter{Text: "joined", Stream: pks.StreamAll}, true},
+		{&LineFilter{Text: "", Stream: pks.StreamAll}, true},
+		{&LineFilter{Text: "Joined", Stream: pks.StreamAll}, false},
+		{&LineFilter{Text: "[31m", Stream: pks.StreamAll}, false}, // colors are stripped
+		{&LineFilter{Text: "joined", Stream: pks.StreamStderr}, true},
+		{&LineFilter{Text: "joined", Stream: pks.StreamStdout}, false},
+		{&LineFilter{Text: "joined", Stream: pks.StreamAll, Since: now.Add(-time.Second), Until: now.Add(time.Second)}, true},
+		{&LineFilter{Text: "joined", Stream: pks.StreamAll, Since: now.Add(time.Second)}, false},
+		{&LineFilter{Text: "joined", Stream: pks.StreamAll, Until: now.Add(-time.Second)}, false},
+		{regex("^Player (joined|left)$"), true},
+		{regex("(?i)^player"), true},
+		{regex("^joined"), false},
+	}
+
+	for _, test := range tests {
+		if got := test.filter.Match(line); got != test.want {
+			t.Errorf("Match() with %+v = %v, want %v", test.filter, got, test.want)
+		}
+	}
+
+	_, err := NewLineFilter("(unclosed", true)
+	if err == nil {
+		t.Error("NewLineFilter() accepted an invalid pattern")
+	}
+
+	filter, err := NewLineFilter("(unclosed", false)
+	if err != nil || filter.Text != "(unclosed" || filter.Stream != pks.StreamAll {
+		t.Errorf("NewLineFilter() = %+v, %v", filter, err)
+	}
+}
+
+func TestConsoleSearch(t *testing.T) {
+	lf, err := NewLogFile(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lf.Close()
+
+	uid, err := uuid.NewV4()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	con := &Console{
+		UUID:    uid,
+		Logs:    NewLogStacker(3),
+		LogFile: lf,
+		created: time.Now().Add(-time.Minute),
+	}
+
+	for i := 1; i <= 8; i++ {
+		line := &Line{
+			Time: time.Now(),
+			Text: "line " + strconv.Itoa(i),
+		}
+
+		con.Logs.Add(line) // keeps 6 to 8
+
+		err = lf.Write(con.logTag(), line)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		n     int
+		want  []int64
+	}{
+		{"line", 10, []int64{1, 2, 3, 4, 5, 6, 7, 8}},
+		{"line", 2, []int64{7, 8}},
+		{"line", 5, []int64{4, 5, 6, 7, 8}},
+		{"[27]$", 10, []int64{2, 7}},
+		{"^line [1-3]$", 2, []int64{2, 3}},
+		{"none", 10, nil},
+	}
+
+	for _, test := range tests {
+		filter, err := NewLineFilter(test.query, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines, err := con.Search(filter, test.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := seqs(lines); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Search(%q, %d) = %v, want %v", test.query, test.n, got, test.want)
+		}
+	}
+}
@@ -10,23 +10,176 @@ package server
 **/
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/beito123/mimi"
 	"github.com/beito123/mimi/config"
+	uuid "github.com/satori/go.uuid"
 
 	"github.com/sirupsen/logrus"
 )
 
 var logger = logrus.New()
 
+// SetLogLevel sets a log level for the server logger
+func SetLogLevel(level logrus.Level) {
+	logger.SetLevel(level)
+}
+
+const (
+	LimiterMaxCount    = 10
+	LimiterBlockExpire = 5 * time.Minute
+)
+
 // Server
 
-func StartServer(conf config.Config) (*Server, error) {
-	ser := &Server{}
+// StartServer starts a server with conf
+// The server is shut down when ctx is done, use Wait to wait for it
+func StartServer(ctx context.Context, conf config.Config) (*Server, error) {
+	ser := &Server{
+		Config: conf,
+		errCh:  make(chan error, 1),
+	}
+
+	var err error
+
+	if len(conf.Server.UUID) > 0 {
+		ser.UUID, err = uuid.FromString(conf.Server.UUID)
+	} else {
+		ser.UUID, err = uuid.NewV4()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ser.LoaderManager = NewLoaderManager(&PMMPLoader{}, &BedrockLoader{}, &NukkitLoader{}, &JavaLoader{}, &ExecLoader{})
+
+	ser.ProgramManager, err = NewProgramManager(ser.LoaderManager, conf.Server.DataDir, conf.Programs)
+	if err != nil {
+		return nil, err
+	}
+
+	ser.ConsoleManager = NewConsoleManager()
+
+	users, err := NewUserManager(conf.Server.Token, conf.Server.TokenRole, conf.Users)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := &ServerSessionHandler{
+		ProgramManager: ser.ProgramManager,
+		ConsoleManager: ser.ConsoleManager,
+		IngoreProtocol: conf.Development.IgnoreProtocol,
+	}
+
+	ser.SessionManager = NewSessionManager(ser.UUID, handler)
+
+	handler.SessionManager = ser.SessionManager
+
+	ser.ConsoleManager.OnState = handler.HandleConsoleState
+
+	ser.ConsoleManager.Adopt(ser.ProgramManager)
+
+	mux := http.NewServeMux()
+	mux.Handle(mimi.StreamPath, &StreamHandler{
+		Auth: &AuthHandler{
+			Users: users,
+			Limiter: &Limiter{
+				MaxCount:    LimiterMaxCount,
+				BlockExpire: LimiterBlockExpire,
+			},
+		},
+		Manager: ser.SessionManager,
+	})
+
+	ser.http = &http.Server{
+		Addr:    fmt.Sprintf(":%d", conf.Server.Port),
+		Handler: mux,
+	}
+
+	ln, err := net.Listen("tcp", ser.http.Addr)
+	if err != nil {
+		return nil, err
+	}
 
-	//
+	ctx, ser.cancel = context.WithCancel(ctx)
+
+	ser.wg.Add(3)
+
+	go func() {
+		defer ser.wg.Done()
+
+		ser.ConsoleManager.Start(ctx)
+	}()
+
+	go func() {
+		defer ser.wg.Done()
+
+		ser.SessionManager.Start(ctx)
+	}()
+
+	go func() {
+		defer ser.wg.Done()
+
+		<-ctx.Done()
+
+		sctx, cancel := context.WithTimeout(context.Background(), mimi.ShutdownTimeout)
+		defer cancel()
+
+		ser.http.Shutdown(sctx)
+	}()
+
+	go func() {
+		err := ser.http.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
+			ser.errCh <- err
+			ser.cancel()
+		}
+	}()
+
+	logger.Infof("Started a server on %s (UUID: %s)", ln.Addr().String(), ser.UUID.String())
 
 	return ser, nil
 }
 
 type Server struct {
 	Config config.Config
+	UUID   uuid.UUID
+
+	LoaderManager  *LoaderManager
+	ProgramManager *ProgramManager
+	ConsoleManager *ConsoleManager
+	SessionManager *SessionManager
+
+	http   *http.Server
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	errCh  chan error
+}
+
+// Shutdown stops the server
+func (ser *Server) Shutdown() {
+	ser.cancel()
+}
+
+// Wait waits for the server to be shut down
+// It returns an error happened while serving
+func (ser *Server) Wait() error {
+	ser.wg.Wait()
+
+	logger.Infof("Shut down the server")
+
+	select {
+	case err := <-ser.errCh:
+		return err
+	default:
+	}
+
+	return nil
 }
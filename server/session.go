@@ -14,46 +14,193 @@ import (
 	"time"
 
 	"github.com/beito123/mimi"
+	"github.com/beito123/mimi/color"
 	"github.com/beito123/mimi/pks"
+	"github.com/beito123/mimi/util"
 )
 
 type ServerSession struct {
 	mimi.BaseSession
 
-	console *Console
-	tracker *LogTracker
+	User *User // authenticated on connecting
+
+	console    *Console
+	subscriber *Subscriber
+	format     byte
 }
 
 func (session *ServerSession) Update(handlers []mimi.PacketHandler) {
-	session.Update(handlers)
+	session.Process(session, handlers)
+}
 
-	if session.State() != mimi.StateConnected {
-		return
+// forward sends the last n lines before seq, and lines and states published after seq
+// It returns when the subscriber is unsubscribed
+func (session *ServerSession) forward(con *Console, sub *Subscriber, seq int64, n int) {
+	if n > 0 {
+		lines, err := con.History(seq+1, n)
+		if err != nil {
+			logger.Errorf("Couldn't read logs of %s: %s", con.Program.Name, err.Error())
+		}
+
+		session.sendLines(lines)
 	}
 
-	if session.console == nil {
-		return
+	for {
+		var statuses []*Status
+		var lines []*Line
+
+		select {
+		case line, ok := <-sub.Lines():
+			if !ok {
+				return
+			}
+
+			lines = append(lines, line)
+		case status, ok := <-sub.States():
+			if !ok {
+				return
+			}
+
+			statuses = append(statuses, status)
+		}
+
+		// statuses are sent after lines published before them
+	collectStates:
+		for {
+			select {
+			case status, ok := <-sub.States():
+				if !ok {
+					return
+				}
+
+				statuses = append(statuses, status)
+			default:
+				break collectStates
+			}
+		}
+
+	collectLines:
+		for len(lines) < MaxSubscriberLines {
+			select {
+			case line, ok := <-sub.Lines():
+				if !ok {
+					return
+				}
+
+				lines = append(lines, line)
+			default:
+				break collectLines
+			}
+		}
+
+		for _, status := range statuses {
+			i := 0
+			for i < len(lines) && lines[i].Seq <= status.Seq {
+				i++
+			}
+
+			seq = session.sendBatch(lines[:i], seq)
+			lines = lines[i:]
+
+			session.SendPacket(status.Packet)
+		}
+
+		seq = session.sendBatch(lines, seq)
 	}
+}
 
-	session.SendPacket(&pks.ConsoleMessages{
-		Messages: session.console.Lines(session.tracker),
-	})
+// sendBatch sends lines published after seq, and returns seq of the last line sent
+// A marker is sent instead of lines dropped after seq
+func (session *ServerSession) sendBatch(batch []*Line, seq int64) int64 {
+	var lines []*Line
+	for _, line := range batch {
+		// lines published before seq have been sent as history
+		if line.Seq <= seq {
+			continue
+		}
+
+		if line.Seq > seq+1 {
+			lines = append(lines, NewDroppedLine(seq+1, line.Seq-seq-1))
+		}
+
+		lines = append(lines, line)
+		seq = line.Seq
+	}
+
+	session.sendLines(lines)
+
+	return seq
+}
+
+// sendLines sends lines in ConsoleMessages packets
+func (session *ServerSession) sendLines(lines []*Line) {
+	for len(lines) > 0 {
+		n := util.MinInt(len(lines), MaxLines)
+
+		var messages []*pks.Message
+		for _, line := range lines[:n] {
+			messages = append(messages, NewMessage(line, session.format))
+		}
+
+		session.SendPacket(&pks.ConsoleMessages{
+			Messages: messages,
+		})
+
+		lines = lines[n:]
+	}
+}
+
+// NewMessage converts a line to a message in format
+func NewMessage(line *Line, format byte) *pks.Message {
+	msg := &pks.Message{
+		Stream: line.Stream,
+		Seq:    line.Seq,
+		Time:   UnixMilli(line.Time),
+	}
+
+	switch format {
+	case pks.FormatPlain:
+		msg.Text = color.Strip(line.Text)
+	case pks.FormatSpans:
+		spans := color.Parse(line.Text)
+		for _, span := range spans {
+			msg.Text += span.Text
+			msg.Spans = append(msg.Spans, &pks.Span{
+				Text:  span.Text,
+				Color: span.Color,
+				Style: byte(span.Style),
+			})
+		}
+	default:
+		msg.Text = line.Text
+	}
+
+	return msg
 }
 
 func (session *ServerSession) HasJoined() bool {
 	return session.console != nil
 }
 
-func (session *ServerSession) JoinConsole(con *Console) error {
+// JoinConsole joins a console and sends the last n lines
+func (session *ServerSession) JoinConsole(con *Console, format byte, n int) error {
 	if con.Closed() {
 		return errors.New("already closed the console")
 	}
 
+	if session.HasJoined() {
+		session.QuitConsole()
+	}
+
 	session.console = con
-	session.tracker = NewLogTracker()
+	session.subscriber = con.Subscribe()
+	session.format = format
 
 	logger.Debugf("Session(%s) joins a console(%s)", session.Addr().String(), con.UUID.String())
 
+	// lines may be published before the seq, they're skipped by forward
+	go session.forward(con, session.subscriber, con.Logs.Seq(), n)
+
 	return nil
 }
 
@@ -62,8 +209,10 @@ func (session *ServerSession) QuitConsole() error {
 		return errors.New("not joined a console")
 	}
 
+	session.console.Unsubscribe(session.subscriber)
+
 	session.console = nil
-	session.tracker = nil
+	session.subscriber = nil
 
 	return nil
 }
@@ -71,10 +220,13 @@ func (session *ServerSession) QuitConsole() error {
 type ServerSessionHandler struct {
 	ProgramManager *ProgramManager
 	ConsoleManager *ConsoleManager
+	SessionManager *SessionManager
 	Console        *Console
 	IngoreProtocol bool
 }
 
+// HandlePacket handles a request of session
+// Packets of all sessions are handled in one goroutine, so replies don't wait for a slow client
 func (sp *ServerSessionHandler) HandlePacket(session mimi.Session, pk pks.Packet) {
 	switch npk := pk.(type) {
 	case *pks.ConnectionRequest:
@@ -88,8 +240,8 @@ func (sp *ServerSessionHandler) HandlePacket(session mimi.Session, pk pks.Packet
 			return
 		}
 
-		if npk.ClientProtocol != mimi.ProtocolVersion && !sp.IngoreProtocol {
-			session.SendPacket(&pks.IncompatibleProtocol{
+		if (npk.ClientProtocol < mimi.MinProtocolVersion || npk.ClientProtocol > mimi.ProtocolVersion) && !sp.IngoreProtocol {
+			session.TrySendPacket(&pks.IncompatibleProtocol{
 				Protocol: mimi.ProtocolVersion,
 			})
 
@@ -98,11 +250,15 @@ func (sp *ServerSessionHandler) HandlePacket(session mimi.Session, pk pks.Packet
 			return
 		}
 
+		if npk.ClientProtocol <= mimi.ProtocolVersion {
+			session.SetProtocol(npk.ClientProtocol)
+		}
+
 		session.SetClientUUID(npk.ClientUUID)
 
 		session.SetState(mimi.StateConnected)
 
-		session.SendPacket(&pks.ConnectionResponse{
+		session.TrySendPacket(&pks.ConnectionResponse{
 			Time: time.Now().Unix(),
 		})
 
@@ -119,42 +275,104 @@ func (sp *ServerSessionHandler) HandlePacket(session mimi.Session, pk pks.Packet
 			})
 		}
 
-		session.SendPacket(rpk)
+		session.TrySendPacket(rpk)
 	case *pks.StartProgram:
 		logger.Debugf("Received a StartProgram packet\n")
 
+		if !sp.allowed(session, pk.ID()) {
+			return
+		}
+
 		program, ok := sp.ProgramManager.Get(npk.ProgramName)
 		if !ok {
-			session.SendPacket(&pks.ErrorMessage{
-				Error: mimi.ErrIDProgramNotFound,
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDProgramNotFound,
+				Request: pk.ID(),
 			})
 
 			return
 		}
 
-		con, err := sp.ConsoleManager.NewConsole(program.Loader)
-		if err != nil {
+		con, err := sp.ConsoleManager.NewConsole(program)
+		if err == errProgramRunning || err == errLocked {
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDProgramAlreadyRunning,
+				Request: pk.ID(),
+			})
+
+			return
+		} else if err != nil {
 			logger.Errorln(err)
 
-			session.SendPacket(&pks.ErrorMessage{
-				Error: mimi.ErrIDInternalError,
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDInternalError,
+				Request: pk.ID(),
 			})
 
 			return
 		}
 
-		session.SendPacket(&pks.ProgramStatus{
-			ProgramName: program.Name,
-			ConsoleUUID: con.UUID,
-			Running:     true,
+		session.TrySendPacket(NewProgramStatus(con, con.State()))
+	case *pks.StopProgram:
+		logger.Debugf("Received a StopProgram packet\n")
+
+		if !sp.allowed(session, pk.ID()) {
+			return
+		}
+
+		program, ok := sp.ProgramManager.Get(npk.ProgramName)
+		if !ok {
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDProgramNotFound,
+				Request: pk.ID(),
+			})
+
+			return
+		}
+
+		con, ok := program.Console()
+		if !ok {
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDConsoleNotFound,
+				Request: pk.ID(),
+			})
+
+			return
+		}
+
+		// it may take a while to stop a program
+		go sp.stopProgram(session, pk.ID(), program, con, npk.Restart)
+	case *pks.RequestConsoleList:
+		logger.Debugf("Received a RequestConsoleList packet\n")
+
+		rpk := &pks.ResponseConsoleList{}
+
+		sp.ConsoleManager.Range(func(con *Console) bool {
+			if con.Closed() {
+				return true
+			}
+
+			rpk.Consoles = append(rpk.Consoles, &pks.Console{
+				UUID: con.UUID,
+				Program: &pks.Program{
+					Name:       con.Program.Name,
+					LoaderName: con.Program.Loader.Name(),
+				},
+				State: con.State(),
+			})
+
+			return true
 		})
+
+		session.TrySendPacket(rpk)
 	case *pks.JoinConsole:
 		logger.Debugf("Received a JoinConsole packet\n")
 
 		con, ok := sp.ConsoleManager.Get(npk.ConsoleUUID)
 		if !ok {
-			session.SendPacket(&pks.ErrorMessage{
-				Error: mimi.ErrIDConsoleNotFound,
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDConsoleNotFound,
+				Request: pk.ID(),
 			})
 
 			return
@@ -164,27 +382,36 @@ func (sp *ServerSessionHandler) HandlePacket(session mimi.Session, pk pks.Packet
 		if !ok {
 			mimi.Error("couldn't convert to *ServerSession")
 
-			session.SendPacket(&pks.ErrorMessage{
-				Error: mimi.ErrIDInternalError,
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDInternalError,
+				Request: pk.ID(),
 			})
 
 			return
 		}
 
 		if con.Closed() {
-			session.SendPacket(&pks.ErrorMessage{
-				Error: mimi.ErrIDConsoleAlreadyClosed,
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDConsoleAlreadyClosed,
+				Request: pk.ID(),
 			})
 
 			return
 		}
 
-		err := serSession.JoinConsole(con)
+		// older clients have been sent all kept lines
+		lines := int(npk.Lines)
+		if session.Protocol() < 6 {
+			lines = DefaultLogSize
+		}
+
+		err := serSession.JoinConsole(con, npk.Format, lines)
 		if err != nil {
 			mimi.Error("couldn't join a console error: %s", err.Error())
 
-			session.SendPacket(&pks.ErrorMessage{
-				Error: mimi.ErrIDInternalError,
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDInternalError,
+				Request: pk.ID(),
 			})
 		}
 	case *pks.QuitConsole:
@@ -194,16 +421,18 @@ func (sp *ServerSessionHandler) HandlePacket(session mimi.Session, pk pks.Packet
 		if !ok {
 			mimi.Error("couldn't convert to *ServerSession")
 
-			session.SendPacket(&pks.ErrorMessage{
-				Error: mimi.ErrIDInternalError,
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDInternalError,
+				Request: pk.ID(),
 			})
 
 			return
 		}
 
 		if !serSession.HasJoined() {
-			session.SendPacket(&pks.ErrorMessage{
-				Error: mimi.ErrIDSessionNotJoinedConsole,
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDSessionNotJoinedConsole,
+				Request: pk.ID(),
 			})
 
 			return
@@ -213,10 +442,188 @@ func (sp *ServerSessionHandler) HandlePacket(session mimi.Session, pk pks.Packet
 		if err != nil {
 			mimi.Error("couldn't join a console error: %s", err.Error())
 
-			session.SendPacket(&pks.ErrorMessage{
-				Error: mimi.ErrIDInternalError,
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDInternalError,
+				Request: pk.ID(),
 			})
 		}
+	case *pks.SendCommands:
+		logger.Debugf("Received a SendCommands packet\n")
+
+		if !sp.allowed(session, pk.ID()) {
+			return
+		}
+
+		serSession, ok := session.(*ServerSession)
+		if !ok {
+			mimi.Error("couldn't convert to *ServerSession")
+
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDInternalError,
+				Request: pk.ID(),
+			})
+
+			return
+		}
+
+		if !serSession.HasJoined() {
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDSessionNotJoinedConsole,
+				Request: pk.ID(),
+			})
+
+			return
+		}
+
+		con := serSession.console
+		if con.Closed() {
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDConsoleAlreadyClosed,
+				Request: pk.ID(),
+			})
+
+			return
+		}
+
+		for _, cmd := range npk.Commands {
+			err := con.SendCommand(cmd)
+			if err == errSendFull {
+				session.TrySendPacket(&pks.ErrorMessage{
+					Error:   mimi.ErrIDConsoleBusy,
+					Request: pk.ID(),
+				})
+
+				return
+			} else if err != nil {
+				session.TrySendPacket(&pks.ErrorMessage{
+					Error:   mimi.ErrIDConsoleAlreadyClosed,
+					Request: pk.ID(),
+				})
+
+				return
+			}
+		}
+	case *pks.ResizeConsole:
+		logger.Debugf("Received a ResizeConsole packet\n")
+
+		// clients of viewers send their terminal size too, it's ignored without an error
+		if !permitted(session, pk.ID()) {
+			return
+		}
+
+		serSession, ok := session.(*ServerSession)
+		if !ok {
+			mimi.Error("couldn't convert to *ServerSession")
+
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDInternalError,
+				Request: pk.ID(),
+			})
+
+			return
+		}
+
+		if !serSession.HasJoined() || serSession.console.UUID != npk.ConsoleUUID {
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDSessionNotJoinedConsole,
+				Request: pk.ID(),
+			})
+
+			return
+		}
+
+		err := serSession.console.Resize(npk.Rows, npk.Cols)
+		if err != nil {
+			logger.Debugf("Couldn't resize a console(%s): %s", npk.ConsoleUUID.String(), err.Error())
+		}
+	case *pks.RequestExitStatus:
+		logger.Debugf("Received a RequestExitStatus packet\n")
+
+		program, ok := sp.ProgramManager.Get(npk.ProgramName)
+		if !ok {
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDProgramNotFound,
+				Request: pk.ID(),
+			})
+
+			return
+		}
+
+		uid, exit := program.LastExit()
+		if exit == nil {
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDNoExitStatus,
+				Request: pk.ID(),
+			})
+
+			return
+		}
+
+		session.TrySendPacket(&pks.ExitStatus{
+			ProgramName: program.Name,
+			ConsoleUUID: uid,
+			Exit:        NewExit(exit),
+		})
+	case *pks.RequestConsoleHistory:
+		logger.Debugf("Received a RequestConsoleHistory packet\n")
+
+		con, ok := sp.ConsoleManager.Get(npk.ConsoleUUID)
+		if !ok {
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDConsoleNotFound,
+				Request: pk.ID(),
+			})
+
+			return
+		}
+
+		count := int(npk.Count)
+		if count == 0 {
+			count = DefaultHistoryLines
+		}
+
+		// log files may be read
+		go sp.sendHistory(session, con, npk.Seq, util.MinInt(count, MaxHistoryLines), npk.Format)
+	case *pks.RequestSearch:
+		logger.Debugf("Received a RequestSearch packet\n")
+
+		con, ok := sp.ConsoleManager.Get(npk.ConsoleUUID)
+		if !ok {
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDConsoleNotFound,
+				Request: pk.ID(),
+			})
+
+			return
+		}
+
+		filter, err := NewLineFilter(npk.Query, npk.Regexp)
+		if err != nil {
+			session.TrySendPacket(&pks.ErrorMessage{
+				Error:   mimi.ErrIDInvalidQuery,
+				Request: pk.ID(),
+			})
+
+			return
+		}
+
+		filter.Stream = npk.Stream
+
+		if npk.Since > 0 {
+			filter.Since = time.Unix(npk.Since, 0)
+		}
+
+		if npk.Until > 0 {
+			filter.Until = time.Unix(npk.Until, 0)
+		}
+
+		max := int(npk.MaxResults)
+		if max == 0 {
+			max = DefaultSearchResults
+		}
+
+		// log files may be read
+		go sp.search(session, con, filter, util.MinInt(max, MaxSearchResults), npk.Format)
 	case *pks.DisconnectionNotification:
 		logger.Debugf("Received disconnection packet IP: %s CID: %s\n", session.Addr().String(), session.ClientUUID().String())
 
@@ -227,3 +634,162 @@ func (sp *ServerSessionHandler) HandlePacket(session mimi.Session, pk pks.Packet
 		logger.Debugf("Received unknown packet ID:%d\n", npk.ID())
 	}
 }
+
+func (sp *ServerSessionHandler) stopProgram(session mimi.Session, req byte, program *Program, con *Console, restart bool) {
+	var err error
+	if restart {
+		logger.Infof("Restarting %s (console: %s) by %s", program.Name, con.UUID.String(), userName(session))
+
+		err = con.Restart()
+	} else {
+		logger.Infof("Stopping %s (console: %s) by %s", program.Name, con.UUID.String(), userName(session))
+
+		err = con.Stop()
+	}
+
+	if err != nil {
+		logger.Errorln(err)
+
+		session.TrySendPacket(&pks.ErrorMessage{
+			Error:   mimi.ErrIDInternalError,
+			Request: req,
+		})
+	}
+
+	// sessions joined to the console have been notified by their subscribers
+	serSession, ok := session.(*ServerSession)
+	if ok && serSession.HasJoined() && serSession.console.UUID == con.UUID {
+		return
+	}
+
+	session.TrySendPacket(NewProgramStatus(con, con.State()))
+}
+
+// allowed returns whether the user of session can send the request req
+// If not, it sends an error for the request req to session
+func (sp *ServerSessionHandler) allowed(session mimi.Session, req byte) bool {
+	if permitted(session, req) {
+		return true
+	}
+
+	session.TrySendPacket(&pks.ErrorMessage{
+		Error:   mimi.ErrIDPermissionDenied,
+		Request: req,
+	})
+
+	return false
+}
+
+// permitted returns whether the user of session can send a request packet
+func permitted(session mimi.Session, req byte) bool {
+	serSession, ok := session.(*ServerSession)
+
+	return ok && serSession.User != nil && serSession.User.Allowed(req)
+}
+
+// userName returns a name of the user of session for logs
+func userName(session mimi.Session) string {
+	serSession, ok := session.(*ServerSession)
+	if !ok || serSession.User == nil {
+		return "unknown"
+	}
+
+	return serSession.User.Name
+}
+
+// sendHistory sends n lines of a console before seq
+func (sp *ServerSessionHandler) sendHistory(session mimi.Session, con *Console, seq int64, n int, format byte) {
+	lines, err := con.History(seq, n)
+	if err != nil {
+		logger.Errorf("Couldn't read logs of %s: %s", con.Program.Name, err.Error())
+	}
+
+	pk := &pks.ConsoleHistory{
+		ConsoleUUID: con.UUID,
+		More:        len(lines) > 0 && lines[0].Seq > 1,
+	}
+
+	for _, line := range lines {
+		pk.Messages = append(pk.Messages, NewMessage(line, format))
+	}
+
+	session.TrySendPacket(pk)
+}
+
+// search sends the last n lines of a console matching filter
+func (sp *ServerSessionHandler) search(session mimi.Session, con *Console, filter *LineFilter, n int, format byte) {
+	// one more line is searched to know whether more lines match
+	lines, err := con.Search(filter, n+1)
+	if err != nil {
+		logger.Errorf("Couldn't search logs of %s: %s", con.Program.Name, err.Error())
+	}
+
+	pk := &pks.SearchResult{
+		ConsoleUUID: con.UUID,
+	}
+
+	if len(lines) > n {
+		lines = lines[1:]
+		pk.More = true
+	}
+
+	for _, line := range lines {
+		pk.Messages = append(pk.Messages, NewMessage(line, format))
+	}
+
+	session.TrySendPacket(pk)
+}
+
+// HandleConsoleState logs a new state of a console
+// Sessions joined to the console are sent the state by their subscribers
+func (sp *ServerSessionHandler) HandleConsoleState(con *Console, state byte) {
+	logger.Debugf("Console(%s) of %s is %s", con.UUID.String(), con.Program.Name, pks.ConsoleStateName(state))
+}
+
+// NewProgramStatus returns a status of a console in state
+// It has the last exit if the program has exited
+func NewProgramStatus(con *Console, state byte) *pks.ProgramStatus {
+	pk := &pks.ProgramStatus{
+		ProgramName: con.Program.Name,
+		ConsoleUUID: con.UUID,
+		Running:     state != pks.ConsoleStopped && state != pks.ConsoleCrashed,
+		State:       state,
+		Restarting:  con.Restarting(),
+	}
+
+	if exit := con.LastExit(); !pk.Running && exit != nil {
+		pk.Exit = NewExit(exit)
+	}
+
+	return pk
+}
+
+// UnixMilli returns unix time in milliseconds, 0 for the zero time
+func UnixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// NewExit converts an exit record for packets
+func NewExit(exit *Exit) *pks.Exit {
+	pk := &pks.Exit{
+		Code:     int32(exit.Code),
+		Signal:   exit.Signal,
+		Duration: int64(exit.Duration / time.Millisecond),
+		Time:     exit.Time.Unix(),
+	}
+
+	for _, line := range exit.Lines {
+		pk.Lines = append(pk.Lines, &pks.Message{
+			Text:   line.Text,
+			Stream: line.Stream,
+			Seq:    line.Seq,
+			Time:   UnixMilli(line.Time),
+		})
+	}
+
+	return pk
+}
@@ -0,0 +1,195 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beito123/mimi"
+	"github.com/beito123/mimi/pks"
+	"github.com/gorilla/websocket"
+	uuid "github.com/satori/go.uuid"
+)
+
+// newTestSession returns a session of user and a connection of the other side
+func newTestSession(t *testing.T, user *User) (*ServerSession, *websocket.Conn) {
+	sessionCh := make(chan *ServerSession, 1)
+
+	ser := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(rw, req, nil)
+		if err != nil {
+			t.Error(err)
+
+			return
+		}
+
+		session := &ServerSession{
+			BaseSession: mimi.NewBaseSession(conn, uuid.Nil, uuid.Nil),
+			User:        user,
+		}
+
+		session.SetState(mimi.StateConnected)
+		session.Start()
+
+		sessionCh <- session
+	}))
+
+	t.Cleanup(ser.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ser.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	session := <-sessionCh
+
+	t.Cleanup(session.Close)
+
+	return session, conn
+}
+
+// receive returns a packet received by conn, or nil if nothing is received in a while
+func receive(t *testing.T, conn *websocket.Conn) pks.Packet {
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+
+	_, b, err := conn.ReadMessage()
+	if err != nil {
+		return nil
+	}
+
+	pk, ok := pks.GetPacket(b[0])
+	if !ok {
+		t.Fatalf("received a unknown packet (0x%x)", b[0])
+	}
+
+	pk.SetBytes(b)
+	pk.SetProtocol(mimi.ProtocolVersion)
+
+	err = pk.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pk
+}
+
+func TestHandlePacketPermission(t *testing.T) {
+	tests := []struct {
+		role Role
+		pk   pks.Packet
+		want int // error sent, or 0 if none
+	}{
+		{RoleViewer, &pks.SendCommands{Commands: []string{"stop"}}, mimi.ErrIDPermissionDenied},
+		{RoleOperator, &pks.StartProgram{ProgramName: "lobby"}, mimi.ErrIDPermissionDenied},
+		{RoleOperator, &pks.StopProgram{ProgramName: "lobby"}, mimi.ErrIDPermissionDenied},
+		{RoleViewer, &pks.ResizeConsole{Rows: 24, Cols: 80}, 0}, // ignored
+		{RoleOperator, &pks.SendCommands{Commands: []string{"stop"}}, mimi.ErrIDSessionNotJoinedConsole},
+		{RoleOperator, &pks.ResizeConsole{Rows: 24, Cols: 80}, mimi.ErrIDSessionNotJoinedConsole},
+	}
+
+	hand := &ServerSessionHandler{}
+
+	for _, test := range tests {
+		session, conn := newTestSession(t, &User{Name: "user", Role: test.role})
+
+		hand.HandlePacket(session, test.pk)
+
+		var got int
+		if pk := receive(t, conn); pk != nil {
+			epk, ok := pk.(*pks.ErrorMessage)
+			if !ok {
+				t.Fatalf("received %T", pk)
+			}
+
+			got = epk.Error
+
+			if epk.Request != test.pk.ID() {
+				t.Errorf("error for 0x%x is sent for 0x%x", epk.Request, test.pk.ID())
+			}
+		}
+
+		if got != test.want {
+			t.Errorf("%T of %s: error = %d, want %d", test.pk, test.role, got, test.want)
+		}
+	}
+}
+
+func TestSessionForward(t *testing.T) {
+	tests := []struct {
+		seq      int64 // seq of the last line sent before
+		lines    []int64
+		statuses []int64 // seq of the last line before each status
+		want     string
+	}{
+		{0, []int64{1, 2, 3}, nil, "1 2 3"},
+		{0, []int64{1, 2, 5, 6}, []int64{2, 6}, "1 2 status dropped:3 5 6 status"},
+		{2, []int64{1, 2, 3, 4}, nil, "3 4"},              // sent as history
+		{0, []int64{4}, []int64{0}, "status dropped:1 4"}, // dropped lines are marked when the next line comes
+		{3, nil, []int64{3}, "status"},
+	}
+
+	for _, test := range tests {
+		session, conn := newTestSession(t, &User{Role: RoleViewer})
+
+		sub := &Subscriber{
+			lines:  make(chan *Line, MaxSubscriberLines),
+			states: make(chan *Status, MaxSubscriberStates),
+		}
+
+		for _, seq := range test.lines {
+			sub.publish(&Line{Seq: seq, Text: "line"})
+		}
+
+		for _, seq := range test.statuses {
+			sub.publishState(&Status{Packet: &pks.ProgramStatus{}, Seq: seq})
+		}
+
+		go session.forward(nil, sub, test.seq, 0)
+
+		var got []string
+		for {
+			pk := receive(t, conn)
+			if pk == nil {
+				break
+			}
+
+			switch npk := pk.(type) {
+			case *pks.ConsoleMessages:
+				for _, msg := range npk.Messages {
+					if msg.Stream == pks.StreamDropped {
+						got = append(got, "dropped:"+strconv.FormatInt(msg.Seq, 10))
+					} else {
+						got = append(got, strconv.FormatInt(msg.Seq, 10))
+					}
+				}
+			case *pks.ProgramStatus:
+				got = append(got, "status")
+			default:
+				t.Fatalf("received %T", pk)
+			}
+		}
+
+		close(sub.lines)
+		close(sub.states)
+
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("forward() after %d of %v and statuses %v sent %q, want %q", test.seq, test.lines, test.statuses, strings.Join(got, " "), test.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/beito123/mimi/pks"
+)
+
+// MaxSubscriberLines is the number of lines buffered for a subscriber
+// Lines are dropped while the buffer is full
+const MaxSubscriberLines = 1024
+
+// MaxSubscriberStates is the number of statuses buffered for a subscriber
+const MaxSubscriberStates = 16
+
+// Status is a status of a console published to subscribers
+type Status struct {
+	Packet *pks.ProgramStatus
+	Seq    int64 // seq of the last line published before the status
+}
+
+// Subscriber receives lines and statuses published by a console
+type Subscriber struct {
+	lines  chan *Line
+	states chan *Status
+}
+
+// Lines returns a channel of lines, it's closed when unsubscribed
+func (sub *Subscriber) Lines() <-chan *Line {
+	return sub.lines
+}
+
+// States returns a channel of statuses, it's closed when unsubscribed
+func (sub *Subscriber) States() <-chan *Status {
+	return sub.states
+}
+
+// publish adds a line to the buffer without blocking
+func (sub *Subscriber) publish(line *Line) {
+	select {
+	case sub.lines <- line:
+	default:
+	}
+}
+
+// publishState adds a status to the buffer without blocking
+func (sub *Subscriber) publishState(status *Status) {
+	select {
+	case sub.states <- status:
+	default:
+		logger.Warnf("Dropped a console state for a slow session")
+	}
+}
+
+// Subscribe returns a subscriber receiving lines printed after now
+func (con *Console) Subscribe() *Subscriber {
+	sub := &Subscriber{
+		lines:  make(chan *Line, MaxSubscriberLines),
+		states: make(chan *Status, MaxSubscriberStates),
+	}
+
+	con.subMutex.Lock()
+	con.subscribers = append(con.subscribers, sub)
+	con.subMutex.Unlock()
+
+	return sub
+}
+
+// Unsubscribe stops sending lines to sub and closes its channel
+func (con *Console) Unsubscribe(sub *Subscriber) {
+	con.subMutex.Lock()
+	defer con.subMutex.Unlock()
+
+	for i, v := range con.subscribers {
+		if v == sub {
+			con.subscribers = append(con.subscribers[:i], con.subscribers[i+1:]...)
+
+			close(sub.lines)
+			close(sub.states)
+
+			return
+		}
+	}
+}
+
+// publish sends a line to subscribers
+func (con *Console) publish(line *Line) {
+	con.subMutex.RLock()
+	defer con.subMutex.RUnlock()
+
+	for _, sub := range con.subscribers {
+		sub.publish(line)
+	}
+}
+
+// publishState sends a status of state to subscribers
+// Statuses are made when the state is changed, and each subscriber has its own packet
+func (con *Console) publishState(state byte) {
+	con.subMutex.RLock()
+	defer con.subMutex.RUnlock()
+
+	seq := con.Logs.Seq()
+	for _, sub := range con.subscribers {
+		sub.publishState(&Status{
+			Packet: NewProgramStatus(con, state),
+			Seq:    seq,
+		})
+	}
+}
+
+// NewDroppedLine returns a marker of n lines dropped from seq
+func NewDroppedLine(seq int64, n int64) *Line {
+	return &Line{
+		Seq:    seq,
+		Time:   time.Now(),
+		Stream: pks.StreamDropped,
+		Text:   "[mimi] " + strconv.FormatInt(n, 10) + " lines were dropped",
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/beito123/mimi/pks"
+)
+
+func TestSubscriberPublish(t *testing.T) {
+	sub := &Subscriber{
+		lines:  make(chan *Line, 3),
+		states: make(chan *Status, 1),
+	}
+
+	// publishing doesn't wait for a slow subscriber
+	for i := int64(1); i <= 5; i++ {
+		sub.publish(&Line{Seq: i})
+	}
+
+	sub.publishState(&Status{Seq: 1})
+	sub.publishState(&Status{Seq: 5})
+
+	close(sub.lines)
+	close(sub.states)
+
+	var lines []*Line
+	for line := range sub.Lines() {
+		lines = append(lines, line)
+	}
+
+	if got := seqs(lines); !reflect.DeepEqual(got, []int64{1, 2, 3}) {
+		t.Errorf("Lines() = %v, want [1 2 3]", got)
+	}
+
+	var states []int64
+	for status := range sub.States() {
+		states = append(states, status.Seq)
+	}
+
+	if !reflect.DeepEqual(states, []int64{1}) {
+		t.Errorf("States() = %v, want [1]", states)
+	}
+}
+
+func TestNewDroppedLine(t *testing.T) {
+	line := NewDroppedLine(4, 2)
+	if line.Seq != 4 || line.Stream != pks.StreamDropped || line.Text != "[mimi] 2 lines were dropped" {
+		t.Errorf("NewDroppedLine(4, 2) = %+v", line)
+	}
+}
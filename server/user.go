@@ -0,0 +1,197 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"github.com/beito123/mimi/config"
+	"github.com/beito123/mimi/pks"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role is a role of a user, a role has permissions of lower roles
+type Role int
+
+const (
+	// RoleViewer can watch consoles
+	RoleViewer Role = iota
+
+	// RoleOperator can send commands to consoles
+	RoleOperator
+
+	// RoleAdmin can start and stop programs
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleViewer:   "viewer",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+func (role Role) String() string {
+	name, ok := roleNames[role]
+	if !ok {
+		return "unknown"
+	}
+
+	return name
+}
+
+// ParseRole returns a role by name ignoring case
+func ParseRole(name string) (Role, error) {
+	for role, n := range roleNames {
+		if strings.EqualFold(n, name) {
+			return role, nil
+		}
+	}
+
+	return 0, errors.New("unknown role " + name)
+}
+
+// User is an authenticated user
+type User struct {
+	Name string
+	Role Role
+
+	hash []byte
+}
+
+// Has returns whether the user has permissions of role
+func (user *User) Has(role Role) bool {
+	return user.Role >= role
+}
+
+// RequiredRoles are roles required for requests, viewers can send others
+var RequiredRoles = map[byte]Role{
+	pks.IDStartProgram:  RoleAdmin,
+	pks.IDStopProgram:   RoleAdmin,
+	pks.IDSendCommands:  RoleOperator,
+	pks.IDResizeConsole: RoleOperator,
+}
+
+// Allowed returns whether the user can send a request packet
+func (user *User) Allowed(req byte) bool {
+	return user.Has(RequiredRoles[req])
+}
+
+// TokenUserName is a name of a user authenticated by the token in the server config
+const TokenUserName = "token"
+
+// NewUserManager returns a UserManager having users
+// The token has tokenRole, or admin if it's empty. If users are configured,
+// the token is disabled unless tokenRole is set
+func NewUserManager(token string, tokenRole string, users []config.UserConfig) (*UserManager, error) {
+	um := &UserManager{
+		Token: token,
+		TokenUser: &User{
+			Name: TokenUserName,
+			Role: RoleAdmin,
+		},
+		Users: make(map[string]*User),
+	}
+
+	if len(tokenRole) > 0 {
+		role, err := ParseRole(tokenRole)
+		if err != nil {
+			return nil, errors.New("invalid role of the token")
+		}
+
+		um.TokenUser.Role = role
+	} else if len(token) > 0 && len(users) > 0 {
+		logger.Warnf("The token is disabled because users are configured, set token_role to use it")
+
+		um.Token = ""
+	}
+
+	dummyCost := 0
+
+	for _, uc := range users {
+		if len(uc.Name) == 0 {
+			return nil, errors.New("a user without name")
+		}
+
+		if _, ok := um.Users[uc.Name]; ok {
+			return nil, errors.New("duplicate user " + uc.Name)
+		}
+
+		role, err := ParseRole(uc.Role)
+		if err != nil {
+			return nil, errors.New("invalid role of " + uc.Name)
+		}
+
+		cost, err := bcrypt.Cost([]byte(uc.Password))
+		if err != nil {
+			return nil, errors.New("invalid password hash of " + uc.Name)
+		}
+
+		if cost > dummyCost {
+			dummyCost = cost
+		}
+
+		um.Users[uc.Name] = &User{
+			Name: uc.Name,
+			Role: role,
+			hash: []byte(uc.Password),
+		}
+	}
+
+	if dummyCost == 0 {
+		dummyCost = bcrypt.DefaultCost
+	}
+
+	// compared for unknown users, so they take as long as known users
+	dummy, err := bcrypt.GenerateFromPassword([]byte(TokenUserName), dummyCost)
+	if err != nil {
+		return nil, err
+	}
+
+	um.dummy = dummy
+
+	return um, nil
+}
+
+// UserManager authenticates users
+type UserManager struct {
+	Token     string // disabled if it's empty
+	TokenUser *User
+	Users     map[string]*User
+
+	dummy []byte
+}
+
+// Auth returns a user if the password is correct
+func (um *UserManager) Auth(name string, password string) (*User, bool) {
+	user, ok := um.Users[name]
+	if !ok {
+		bcrypt.CompareHashAndPassword(um.dummy, []byte(password))
+
+		return nil, false
+	}
+
+	err := bcrypt.CompareHashAndPassword(user.hash, []byte(password))
+	if err != nil {
+		return nil, false
+	}
+
+	return user, true
+}
+
+// AuthToken returns TokenUser if token is correct
+func (um *UserManager) AuthToken(token string) (*User, bool) {
+	if len(um.Token) == 0 || subtle.ConstantTimeCompare([]byte(um.Token), []byte(token)) != 1 {
+		return nil, false
+	}
+
+	return um.TokenUser, true
+}
@@ -0,0 +1,156 @@
+package server
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"testing"
+
+	"github.com/beito123/mimi/config"
+	"github.com/beito123/mimi/pks"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testUser returns a config of a user with the password
+func testUser(t *testing.T, name string, password string, role string) config.UserConfig {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config.UserConfig{
+		Name:     name,
+		Password: string(hash),
+		Role:     role,
+	}
+}
+
+func TestUserManagerAuth(t *testing.T) {
+	um, err := NewUserManager("", "", []config.UserConfig{
+		testUser(t, "alice", "alicepw", "operator"),
+		testUser(t, "bob", "bobpw", "viewer"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, password string
+		ok             bool
+		role           Role
+	}{
+		{"alice", "alicepw", true, RoleOperator},
+		{"bob", "bobpw", true, RoleViewer},
+		{"alice", "bobpw", false, 0},
+		{"alice", "", false, 0},
+		{"carol", "alicepw", false, 0},
+		{TokenUserName, "", false, 0},
+	}
+
+	for _, test := range tests {
+		user, ok := um.Auth(test.name, test.password)
+		if ok != test.ok {
+			t.Errorf("Auth(%q, %q) ok = %v, want %v", test.name, test.password, ok, test.ok)
+
+			continue
+		}
+
+		if ok && (user.Name != test.name || user.Role != test.role) {
+			t.Errorf("Auth(%q, %q) = %s (%s), want %s", test.name, test.password, user.Name, user.Role, test.role)
+		}
+	}
+}
+
+func TestUserManagerAuthToken(t *testing.T) {
+	users := []config.UserConfig{
+		testUser(t, "alice", "alicepw", "admin"),
+	}
+
+	tests := []struct {
+		tokenRole string
+		users     []config.UserConfig
+		ok        bool
+		role      Role
+	}{
+		{"", nil, true, RoleAdmin},
+		{"viewer", nil, true, RoleViewer},
+		{"Operator", nil, true, RoleOperator},
+		{"admin", users, true, RoleAdmin},
+		{"viewer", users, true, RoleViewer},
+		{"", users, false, 0}, // disabled by users
+	}
+
+	for _, test := range tests {
+		um, err := NewUserManager("abc", test.tokenRole, test.users)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := um.AuthToken("abd"); ok {
+			t.Errorf("a wrong token is accepted with token_role %q", test.tokenRole)
+		}
+
+		user, ok := um.AuthToken("abc")
+		if ok != test.ok {
+			t.Errorf("AuthToken() with token_role %q and %d users ok = %v, want %v", test.tokenRole, len(test.users), ok, test.ok)
+
+			continue
+		}
+
+		if ok && user.Role != test.role {
+			t.Errorf("AuthToken() with token_role %q = %s, want %s", test.tokenRole, user.Role, test.role)
+		}
+	}
+
+	_, err := NewUserManager("abc", "owner", nil)
+	if err == nil {
+		t.Error("NewUserManager() accepted an unknown token_role")
+	}
+
+	um, err := NewUserManager("", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := um.AuthToken(""); ok {
+		t.Error("an empty token is accepted")
+	}
+}
+
+func TestUserAllowed(t *testing.T) {
+	tests := []struct {
+		req                     byte
+		viewer, operator, admin bool
+	}{
+		{pks.IDRequestProgramList, true, true, true},
+		{pks.IDRequestConsoleList, true, true, true},
+		{pks.IDJoinConsole, true, true, true},
+		{pks.IDQuitConsole, true, true, true},
+		{pks.IDRequestExitStatus, true, true, true},
+		{pks.IDRequestConsoleHistory, true, true, true},
+		{pks.IDRequestSearch, true, true, true},
+		{pks.IDSendCommands, false, true, true},
+		{pks.IDResizeConsole, false, true, true},
+		{pks.IDStartProgram, false, false, true},
+		{pks.IDStopProgram, false, false, true},
+	}
+
+	for _, test := range tests {
+		for role, want := range map[Role]bool{
+			RoleViewer:   test.viewer,
+			RoleOperator: test.operator,
+			RoleAdmin:    test.admin,
+		} {
+			user := &User{Role: role}
+			if got := user.Allowed(test.req); got != want {
+				t.Errorf("Allowed(0x%x) of %s = %v, want %v", test.req, role, got, want)
+			}
+		}
+	}
+}
@@ -12,6 +12,8 @@ package mimi
 import (
 	"errors"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/beito123/mimi/pks"
 
@@ -41,17 +43,29 @@ type Session interface {
 	SetClientUUID(uuid.UUID)
 	State() ConnectionState
 	SetState(ConnectionState)
+
+	// Protocol returns a protocol version used with the other side
+	Protocol() byte
+	SetProtocol(byte)
 	Update([]PacketHandler)
+	Process(Session, []PacketHandler)
 	Close()
 	SendPacket(pks.Packet) error
 	SendBytes([]byte) error
+
+	// TrySendPacket sends a packet without blocking
+	// The session is closed if the send queue is full
+	TrySendPacket(pks.Packet) error
 }
 
 type PacketHandler interface {
 	HandlePacket(session Session, pk pks.Packet)
 }
 
-var errClosed = errors.New("already closed")
+var (
+	errClosed    = errors.New("already closed")
+	errQueueFull = errors.New("the send queue is full")
+)
 
 func NewBaseSession(conn *websocket.Conn, uid uuid.UUID, cid uuid.UUID) BaseSession {
 	return BaseSession{
@@ -59,6 +73,8 @@ func NewBaseSession(conn *websocket.Conn, uid uuid.UUID, cid uuid.UUID) BaseSess
 		state:      StateConnecting,
 		uuid:       uid,
 		clientUUID: cid,
+		protocol:   ProtocolVersion,
+		closeOnce:  new(sync.Once),
 	}
 }
 
@@ -68,10 +84,13 @@ type BaseSession struct {
 	state      ConnectionState
 	uuid       uuid.UUID
 	clientUUID uuid.UUID
+	protocol   byte
 
 	receivedData chan []byte
 	sendData     chan []byte
 	closeCh      chan bool
+	closeOnce    *sync.Once
+	doneCh       chan bool
 }
 
 func (session *BaseSession) HandleError(err error) {
@@ -106,11 +125,20 @@ func (session *BaseSession) SetState(state ConnectionState) {
 	session.state = state
 }
 
+func (session *BaseSession) Protocol() byte {
+	return session.protocol
+}
+
+func (session *BaseSession) SetProtocol(protocol byte) {
+	session.protocol = protocol
+}
+
 func (session *BaseSession) Start() {
 	session.receivedData = make(chan []byte, MaxReceiveStack)
 	session.sendData = make(chan []byte, MaxSendStack)
 
 	session.closeCh = make(chan bool)
+	session.doneCh = make(chan bool)
 
 	// Receive data
 	go func() {
@@ -123,9 +151,15 @@ func (session *BaseSession) Start() {
 
 			typ, data, err := session.Conn.ReadMessage()
 			if err != nil {
-				session.HandleError(err)
-				session.Close()
-				break
+				if session.State() != StateDisconnected {
+					if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+						session.HandleError(err)
+					}
+
+					session.Close()
+				}
+
+				return
 			}
 
 			switch typ {
@@ -141,40 +175,81 @@ func (session *BaseSession) Start() {
 
 	// Send data
 	go func() {
+		defer close(session.doneCh)
+
 		for {
 			var data []byte
 
 			select {
 			case <-session.closeCh:
+				session.flush()
+
+				session.write(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				session.Conn.Close()
+
 				return
 			case n := <-session.sendData:
 				data = n
 			}
 
-			err := session.Conn.WriteMessage(websocket.BinaryMessage, data)
-			if err != nil {
+			err := session.write(websocket.BinaryMessage, data)
+			if err != nil && err != websocket.ErrCloseSent {
 				session.HandleError(err)
+
+				// the connection can't be used after a write timed out
+				session.Close()
 			}
 		}
 	}()
 }
 
 func (session *BaseSession) Close() {
-	if session.State() != StateDisconnected {
-		return
-	}
+	session.closeOnce.Do(func() {
+		// don't wait for the other side, the queue may be full if it doesn't read
+		session.queue(&pks.DisconnectionNotification{})
 
-	session.sendData = make(chan []byte, MaxSendStack)
-	session.SendPacket(&pks.DisconnectionNotification{})
+		session.SetState(StateDisconnected)
+
+		close(session.closeCh)
+	})
+}
+
+// Done returns a channel closed after the connection is closed
+func (session *BaseSession) Done() <-chan bool {
+	return session.doneCh
+}
 
-	close(session.closeCh)
+// flush writes data remaining in the send queue
+func (session *BaseSession) flush() {
+	for {
+		select {
+		case data := <-session.sendData:
+			err := session.write(websocket.BinaryMessage, data)
+			if err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
 
-	session.Conn.Close()
+// write writes a message to the connection within WriteTimeout
+func (session *BaseSession) write(typ int, data []byte) error {
+	session.Conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
 
-	session.SetState(StateDisconnected)
+	return session.Conn.WriteMessage(typ, data)
 }
 
 func (session *BaseSession) Update(handlers []PacketHandler) {
+	session.Process(session, handlers)
+}
+
+// Process handles received packets with handlers
+// owner is passed to handlers instead of the BaseSession,
+// so sessions embedding BaseSession should pass themselves
+func (session *BaseSession) Process(owner Session, handlers []PacketHandler) {
 	var received [][]byte
 	for i := 0; i < MaxProcessData; i++ {
 		select {
@@ -199,6 +274,7 @@ func (session *BaseSession) Update(handlers []PacketHandler) {
 		}
 
 		pk.SetBytes(b)
+		pk.SetProtocol(session.Protocol())
 
 		err := pk.Decode()
 		if err != nil {
@@ -207,22 +283,69 @@ func (session *BaseSession) Update(handlers []PacketHandler) {
 		}
 
 		for _, hand := range handlers {
-			hand.HandlePacket(session, pk)
+			hand.HandlePacket(owner, pk)
 		}
 	}
 }
 
-// SendPacket sends a encoded packet to session
+// SendPacket encodes a packet and sends it to session
 func (session *BaseSession) SendPacket(pk pks.Packet) error {
+	pk.SetProtocol(session.Protocol())
+
+	err := pk.Encode()
+	if err != nil {
+		return err
+	}
+
 	return session.SendBytes(pk.Bytes())
 }
 
+// TrySendPacket encodes a packet and sends it to session without blocking
+// It closes the session if the send queue is full, the other side doesn't read
+func (session *BaseSession) TrySendPacket(pk pks.Packet) error {
+	if session.State() == StateDisconnected {
+		return errClosed
+	}
+
+	err := session.queue(pk)
+	if err == errQueueFull {
+		session.HandleError(err)
+		session.Close()
+	}
+
+	return err
+}
+
+// queue encodes a packet and adds it to the send queue without blocking
+func (session *BaseSession) queue(pk pks.Packet) error {
+	pk.SetProtocol(session.Protocol())
+
+	err := pk.Encode()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case session.sendData <- pk.Bytes():
+	default:
+		return errQueueFull
+	}
+
+	return nil
+}
+
+// SendBytes adds data to the send queue
+// It blocks while the queue is full, and fails after the session is closed
 func (session *BaseSession) SendBytes(data []byte) error {
 	if session.State() == StateDisconnected {
 		return errClosed
 	}
 
-	session.sendData <- data // bad hack // TODO: fix blocking
+	select {
+	case session.sendData <- data:
+	case <-session.closeCh:
+		return errClosed
+	}
 
 	return nil
 }
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package util
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"os"
+	"syscall"
+)
+
+// ProcessExists returns whether a process of pid exists
+func ProcessExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = p.Signal(syscall.Signal(0))
+
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,83 @@
+package util
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClockTicks is USER_HZ used for times in /proc, it's 100 on all supported architectures
+const ClockTicks = 100
+
+// ProcessStartTime returns time a process of pid was started
+func ProcessStartTime(pid int) (time.Time, error) {
+	b, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// the command name in parentheses may have spaces
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return time.Time{}, errors.New("invalid stat of a process")
+	}
+
+	// starttime is the 22nd field, fields after the command name start from the 3rd
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 20 {
+		return time.Time{}, errors.New("invalid stat of a process")
+	}
+
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	boot, err := bootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return boot.Add(time.Duration(ticks) * time.Second / ClockTicks), nil
+}
+
+// bootTime returns time the system was booted
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "btime" {
+			continue
+		}
+
+		sec, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return time.Unix(sec, 0), nil
+	}
+
+	return time.Time{}, errors.New("couldn't find boot time")
+}
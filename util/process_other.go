@@ -0,0 +1,36 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package util
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PSTimeFormat is a format of lstart printed by ps
+const PSTimeFormat = "Mon Jan 2 15:04:05 2006"
+
+// ProcessStartTime returns time a process of pid was started
+func ProcessStartTime(pid int) (time.Time, error) {
+	out, err := exec.Command("ps", "-o", "lstart=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// days are padded with spaces
+	str := strings.Join(strings.Fields(string(out)), " ")
+
+	return time.ParseInLocation(PSTimeFormat, str, time.Local)
+}
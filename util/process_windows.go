@@ -0,0 +1,47 @@
+package util
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// ProcessExists returns whether a process of pid exists
+func ProcessExists(pid int) bool {
+	p, err := os.FindProcess(pid) // it fails if the process doesn't exist on windows
+	if err != nil {
+		return false
+	}
+
+	p.Release()
+
+	return true
+}
+
+// ProcessStartTime returns time a process of pid was started
+func ProcessStartTime(pid int) (time.Time, error) {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	defer syscall.CloseHandle(h)
+
+	var creation, exit, kernel, user syscall.Filetime
+
+	err = syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(0, creation.Nanoseconds()), nil
+}
@@ -49,6 +49,57 @@ func ExistDir(dir string) bool {
 	return err == nil && f.IsDir()
 }
 
+// SplitArgs splits str into arguments like shells
+// Arguments can be quoted with ' or ", and \ escapes a next character
+func SplitArgs(str string) ([]string, error) {
+	var (
+		args    []string
+		arg     []rune
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range str {
+		switch {
+		case escaped:
+			arg = append(arg, r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg = append(arg, r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, string(arg))
+				arg = arg[:0]
+				inArg = false
+			}
+		default:
+			arg = append(arg, r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+
+	if inArg {
+		args = append(args, string(arg))
+	}
+
+	return args, nil
+}
+
 // Runtime
 
 func IsWin() bool {
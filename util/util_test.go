@@ -0,0 +1,47 @@
+package util
+
+/*
+ * mimi
+ *
+ * Copyright (c) 2018 beito
+ *
+ * This software is released under the MIT License.
+ * http://opensource.org/licenses/mit-license.php
+**/
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		str  string
+		want []string
+		err  bool
+	}{
+		{"", nil, false},
+		{"  ", nil, false},
+		{"-Xmx2G  -jar\tserver.jar\nnogui", []string{"-Xmx2G", "-jar", "server.jar", "nogui"}, false},
+		{`-c "echo hello world"`, []string{"-c", "echo hello world"}, false},
+		{`'a "b" \c' "it's"`, []string{`a "b" \c`, "it's"}, false},
+		{`a\ b \"c\"`, []string{"a b", `"c"`}, false},
+		{`"" ''`, []string{"", ""}, false},
+		{`pre"mid"post`, []string{"premidpost"}, false},
+		{`"unterminated`, nil, true},
+		{`trailing\`, nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := SplitArgs(test.str)
+		if (err != nil) != test.err {
+			t.Errorf("SplitArgs(%q) error = %v", test.str, err)
+
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", test.str, got, test.want)
+		}
+	}
+}